@@ -2,6 +2,8 @@ package cli
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/omushpapa/routerman/core"
 	"github.com/omushpapa/routerman/storage"
@@ -27,7 +29,7 @@ var ActionRegisterUser = &Action{
 		if name == "" {
 			return NEXT, ErrInvalidInput
 		}
-		user, err := env.Router.RegisterUser(name)
+		user, err := env.Router.RegisterUserContext(env.Context, name)
 		if err != nil {
 			return NEXT, err
 		}
@@ -39,7 +41,12 @@ var ActionRegisterUser = &Action{
 var ActionListUsers = &Action{
 	Name: "List users",
 	Children: []*Action{
+		ActionShowUserDetails,
 		ActionListUserBandwidthSlots,
+		ActionDisableUserSlots,
+		ActionEnableUserSlots,
+		ActionRenameUser,
+		ActionEditUserInfo,
 		ActionDeregisterUser,
 		ActionListDevices,
 	},
@@ -54,7 +61,7 @@ var ActionListUsers = &Action{
 
 		for {
 			if showList {
-				users, err = env.Store.UserStore.ReadMany(pageSize, pageNumber)
+				users, err = env.Store.UserStore.ReadManyContext(env.Context, pageSize, pageNumber)
 				if err != nil {
 					return NEXT, err
 				}
@@ -70,7 +77,7 @@ var ActionListUsers = &Action{
 
 				dataRows := make([][]string, len(users))
 				for i, user := range users {
-					dataRows[i] = []string{user.Name}
+					dataRows[i] = []string{user.Name, user.Contact}
 				}
 				err = PrintTable(env.Out, dataRows, true, 2)
 				if err != nil {
@@ -80,13 +87,18 @@ var ActionListUsers = &Action{
 				fmt.Fprintln(env.Out, "no more users found")
 			}
 
-			fmt.Fprintf(env.Out, "\nSelect user by number or scroll with n(ext)/p(revious)/q(uit): ")
+			fmt.Fprintf(env.Out, "\nSelect user by number, scroll with n(ext)/p(revious), search with / or q(uit): ")
 			choice, err := GetInput(env.In)
 			if err != nil {
 				return NEXT, err
 			}
 
 			switch choice {
+			case "/":
+				if err = searchDevices(env); err != nil {
+					return NEXT, err
+				}
+				showList = true
 			case "n":
 				if len(users) == pageSize {
 					pageNumber += 1
@@ -116,7 +128,7 @@ var ActionListUsers = &Action{
 
 				fmt.Fprintf(env.Out, "Selected user '%s'\n", user.Name)
 
-				_, err = env.Store.UserStore.Read(userId)
+				_, err = env.Store.UserStore.ReadContext(env.Context, userId)
 				if err != nil {
 					return NEXT, err
 				}
@@ -128,11 +140,52 @@ var ActionListUsers = &Action{
 	},
 }
 
+// searchDevices prompts for a search term and prints the matching devices.
+func searchDevices(env *core.Env) error {
+	fmt.Fprintf(env.Out, "Search by user name, alias, MAC or IP: ")
+	term, err := GetInput(env.In)
+	if err != nil {
+		return err
+	}
+	results, err := env.Router.FindDevicesContext(env.Context, term)
+	if err != nil {
+		if err, ok := err.(*core.SoftError); ok {
+			fmt.Fprintln(env.Out, err.Error())
+			return nil
+		}
+		return err
+	}
+	return PrintSearchResults(env.Out, results)
+}
+
+var ActionShowUserDetails = &Action{
+	Name:            "Show user details",
+	RequiresContext: []string{"userId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		userId, exists := env.Ctx["userId"]
+		if !exists {
+			return NEXT, fmt.Errorf("user id not provided")
+		}
+		details, err := env.Router.GetUserDetailsContext(env.Context, userId)
+		if err != nil {
+			return NEXT, err
+		}
+		if err = PrintUserDetails(env.Out, details); err != nil {
+			return NEXT, err
+		}
+		return REPEAT, nil
+	},
+}
+
 var ActionListUserBandwidthSlots = &Action{
 	Name: "List user bandwidth slots",
 	Children: []*Action{
 		ActionRegisterDevice,
 		ActionAssignSlot,
+		ActionEditSlot,
+		ActionResizeSlot,
+		ActionMoveSlot,
+		ActionToggleSlot,
 		ActionDeleteSlot,
 	},
 	RequiresContext: []string{"userId"},
@@ -144,7 +197,7 @@ var ActionListUserBandwidthSlots = &Action{
 
 		var (
 			err        error
-			slots      []storage.BandwidthSlot
+			slots      []core.UserSlot
 			pageNumber int  = 1
 			pageSize   int  = 5
 			showList   bool = true
@@ -154,16 +207,17 @@ var ActionListUserBandwidthSlots = &Action{
 
 		for {
 			if showList {
-				entries, err := env.Router.GetUserSlots(userId, pageSize, pageNumber)
+				slots, err = env.Router.GetUserSlotsContext(env.Context, userId, pageSize, pageNumber)
 				if err != nil {
 					return NEXT, err
 				}
 
-				dataRows := make([][]string, len(entries))
-				for i, entry := range entries {
+				dataRows := make([][]string, len(slots))
+				for i, slot := range slots {
+					entry := slot.Entry
 					dataRows[i] = []string{
 						fmt.Sprintf(
-							"%s - %s Up:%d/%d Down:%d/%d [%v]\n",
+							"%s - %s Up:%d/%d Down:%d/%d [%v]",
 							entry.StartIp, entry.EndIp, entry.UpMin, entry.UpMax, entry.DownMin, entry.DownMax, entry.Enabled,
 						),
 					}
@@ -207,8 +261,8 @@ var ActionListUserBandwidthSlots = &Action{
 					continue
 				}
 
-				slotId := slots[position].Id
-				_, err = env.Store.BandwidthSlotStore.Read(slotId)
+				slotId := slots[position].Slot.Id
+				_, err = env.Store.BandwidthSlotStore.ReadContext(env.Context, slotId)
 				if err != nil {
 					return NEXT, err
 				}
@@ -261,9 +315,42 @@ var ActionAssignSlot = &Action{
 			}
 		}
 
+		plan, err := choosePlan(env)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+
+		var numDevices int
+		if plan != nil {
+			fmt.Fprintf(env.Out, "Enter number of devices [Default %d]: ", plan.Devices)
+			numDevices, err = GetIntInput(env.In, plan.Devices)
+		} else {
+			fmt.Fprintf(env.Out, "Enter number of devices to use the smallest free block, or leave empty to pick a slot: ")
+			numDevices, err = GetIntInput(env.In, 0)
+		}
+		if err != nil {
+			return NEXT, err
+		}
+		if numDevices > 0 {
+			slot, err := env.Router.AllocateBandwidthSlotContext(env.Context, numDevices, core.BestFit, useDhcpBounds)
+			if err != nil {
+				if err, ok := err.(*core.SoftError); ok {
+					fmt.Fprintln(env.Out, err.Error())
+					return REPEAT, nil
+				}
+				return NEXT, err
+			}
+			fmt.Fprintf(env.Out, "Using %s - %s\n", slot.MinAddress, slot.MaxAddress)
+			return assignSlot(env, userId, slot, "", numDevices, plan)
+		}
+
 		for {
 			if showList {
-				slots, err = env.Router.GetAvailableBandwidthSlots(useDhcpBounds)
+				slots, err = env.Router.GetAvailableBandwidthSlotsContext(env.Context, useDhcpBounds)
 				if err != nil {
 					return NEXT, err
 				}
@@ -327,43 +414,198 @@ var ActionAssignSlot = &Action{
 				capacity, _ := slot.GetCapacity()
 				fmt.Fprintf(env.Out, "Enter number of devices [Default %d]: ", capacity)
 				num, err := GetIntInput(env.In, capacity)
+				if err != nil {
+					return NEXT, err
+				}
 				if num > capacity || num < 1 {
 					return NEXT, fmt.Errorf("invalid number")
 				}
 
-				maxDown := 1000
-				fmt.Fprintf(env.Out, "Enter max download speed (kbps) [Default %d]: ", maxDown)
-				maxDown, err = GetIntInput(env.In, maxDown)
-				if err != nil {
-					return NEXT, err
-				}
+				return assignSlot(env, userId, slot, startIPText, num, plan)
+			}
+		}
 
-				maxUp := 1000
-				fmt.Fprintf(env.Out, "Enter max upload speed (kbps) [Default %d]: ", maxUp)
-				maxUp, err = GetIntInput(env.In, maxUp)
-				if err != nil {
-					return NEXT, err
-				}
+	},
+}
 
-				err = env.Router.AssignSlot(
-					userId, slot, startIPText, capacity, maxUp, maxDown,
-				)
-				if err != nil {
-					if err, ok := err.(*core.SoftError); ok {
-						fmt.Fprintf(env.Out, err.Error())
-						return REPEAT, nil
-					} else {
-						return NEXT, err
-					}
-				}
-				fmt.Fprintln(env.Out, "Entry created successfully")
-				return NEXT, err
+// choosePlan asks for the plan to take the speed limits from. It returns
+// nil when no plans exist or custom limits are wanted.
+func choosePlan(env *core.Env) (*storage.Plan, error) {
+	plans, err := env.Router.GetPlansContext(env.Context)
+	if err != nil || len(plans) == 0 {
+		return nil, err
+	}
+	names := make([]string, 0, len(plans))
+	for _, plan := range plans {
+		names = append(names, plan.Name)
+	}
+	fmt.Fprintf(env.Out, "Plans: %s\n", strings.Join(names, ", "))
+	fmt.Fprintf(env.Out, "Enter plan name, or leave empty for custom limits: ")
+	name, err := GetInput(env.In)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	for _, plan := range plans {
+		if plan.Name == name {
+			return &plan, nil
+		}
+	}
+	return nil, &core.SoftError{Message: fmt.Sprintf("Unknown plan '%s'", name)}
+}
+
+// assignSlot asks for the speed limits, unless they come from a plan, and
+// creates the bandwidth entry.
+func assignSlot(env *core.Env, userId int, slot core.BwSlot, startIP string, numDevices int, plan *storage.Plan) (Navigation, error) {
+	if plan != nil {
+		err := env.Router.AssignPlanSlotContext(env.Context, userId, slot, startIP, numDevices, plan.Name)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "Entry created successfully with plan '%s'\n", plan.Name)
+		return NEXT, nil
+	}
+
+	maxDown := 1000
+	fmt.Fprintf(env.Out, "Enter max download speed (kbps) [Default %d]: ", maxDown)
+	maxDown, err := GetIntInput(env.In, maxDown)
+	if err != nil {
+		return NEXT, err
+	}
+
+	maxUp := 1000
+	fmt.Fprintf(env.Out, "Enter max upload speed (kbps) [Default %d]: ", maxUp)
+	maxUp, err = GetIntInput(env.In, maxUp)
+	if err != nil {
+		return NEXT, err
+	}
+
+	err = env.Router.AssignSlotContext(
+		env.Context, userId, slot, startIP, numDevices, maxUp, maxDown,
+	)
+	if err != nil {
+		if err, ok := err.(*core.SoftError); ok {
+			fmt.Fprintln(env.Out, err.Error())
+			return REPEAT, nil
+		} else {
+			return NEXT, err
+		}
+	}
+	fmt.Fprintln(env.Out, "Entry created successfully")
+	return NEXT, nil
+}
+
+var ActionDisableUserSlots = &Action{
+	Name:            "Disable bandwidth slots",
+	RequiresContext: []string{"userId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return setUserSlotsEnabled(env, false)
+	},
+}
+
+var ActionEnableUserSlots = &Action{
+	Name:            "Enable bandwidth slots",
+	RequiresContext: []string{"userId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return setUserSlotsEnabled(env, true)
+	},
+}
+
+func setUserSlotsEnabled(env *core.Env, enabled bool) (Navigation, error) {
+	userId, exists := env.Ctx["userId"]
+	if !exists {
+		return NEXT, fmt.Errorf("user id not provided")
+	}
+	changed, err := env.Router.SetUserSlotsEnabledContext(env.Context, userId, enabled)
+	if err != nil {
+		if err, ok := err.(*core.SoftError); ok {
+			fmt.Fprintln(env.Out, err.Error())
+			return REPEAT, nil
+		}
+		return NEXT, err
+	}
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	fmt.Fprintf(env.Out, "%d slots %s\n", changed, state)
+	return REPEAT, nil
+}
+
+var ActionRenameUser = &Action{
+	Name:            "Rename user",
+	RequiresContext: []string{"userId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		userId, exists := env.Ctx["userId"]
+		if !exists {
+			return NEXT, fmt.Errorf("user id not provided")
+		}
+		fmt.Fprintf(env.Out, "New name: ")
+		name, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		err = env.Router.RenameUserContext(env.Context, userId, name)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
 			}
+			return NEXT, err
 		}
+		fmt.Fprintln(env.Out, "user renamed")
+		return REPEAT, nil
+	},
+}
 
+var ActionEditUserInfo = &Action{
+	Name:            "Edit contact and notes",
+	RequiresContext: []string{"userId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		userId, exists := env.Ctx["userId"]
+		if !exists {
+			return NEXT, fmt.Errorf("user id not provided")
+		}
+		user, err := env.Store.UserStore.ReadContext(env.Context, userId)
+		if err != nil {
+			return NEXT, err
+		}
+		contact, err := promptText(env, "Contact", user.Contact)
+		if err != nil {
+			return NEXT, err
+		}
+		notes, err := promptText(env, "Notes", user.Notes)
+		if err != nil {
+			return NEXT, err
+		}
+		if err = env.Router.SetUserInfoContext(env.Context, userId, contact, notes); err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "user updated")
+		return REPEAT, nil
 	},
 }
 
+// promptText asks for a value, keeping current when the input is empty and
+// clearing it when the input is "-".
+func promptText(env *core.Env, label, current string) (string, error) {
+	fmt.Fprintf(env.Out, "%s [Default %s, - to clear]: ", label, current)
+	value, err := GetInput(env.In)
+	if err != nil {
+		return current, err
+	}
+	switch value {
+	case "":
+		return current, nil
+	case "-":
+		return "", nil
+	}
+	return value, nil
+}
+
 var ActionDeregisterUser = &Action{
 	Name:            "Deregister user",
 	RequiresContext: []string{"userId"},
@@ -372,7 +614,20 @@ var ActionDeregisterUser = &Action{
 		if !exists {
 			return NEXT, fmt.Errorf("user id not provided")
 		}
-		err := env.Router.DeregisterUser(userId)
+		preview, err := env.Router.PreviewDeregisterUserContext(env.Context, userId)
+		if err != nil {
+			return NEXT, err
+		}
+		confirmed, err := ConfirmRemoval(env, preview)
+		if err != nil {
+			return NEXT, err
+		}
+		if !confirmed {
+			fmt.Fprintln(env.Out, "cancelled")
+			return REPEAT, nil
+		}
+
+		err = env.Router.DeregisterUserContext(env.Context, userId)
 		if err != nil {
 			return NEXT, err
 		}
@@ -390,7 +645,20 @@ var ActionDeleteSlot = &Action{
 		if !exists {
 			return NEXT, fmt.Errorf("slot id not provided")
 		}
-		err := env.Router.DeleteSlot(slotId)
+		preview, err := env.Router.PreviewDeleteSlotContext(env.Context, slotId)
+		if err != nil {
+			return NEXT, err
+		}
+		confirmed, err := ConfirmRemoval(env, preview)
+		if err != nil {
+			return NEXT, err
+		}
+		if !confirmed {
+			fmt.Fprintln(env.Out, "cancelled")
+			return REPEAT, nil
+		}
+
+		err = env.Router.DeleteSlotContext(env.Context, slotId)
 		if err != nil {
 			return NEXT, err
 		}
@@ -400,10 +668,154 @@ var ActionDeleteSlot = &Action{
 	RequiresContext: []string{"slotId"},
 }
 
+var ActionEditSlot = &Action{
+	Name:            "Edit bandwidth limits",
+	RequiresContext: []string{"slotId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		slotId, exists := env.Ctx["slotId"]
+		if !exists {
+			return NEXT, fmt.Errorf("slot id not provided")
+		}
+		slot, err := env.Router.GetSlotContext(env.Context, slotId)
+		if err != nil {
+			return NEXT, err
+		}
+		limits := slot.Limits()
+
+		prompts := []struct {
+			text  string
+			value *int
+		}{
+			{"min upload speed", &limits.UpMin},
+			{"max upload speed", &limits.UpMax},
+			{"min download speed", &limits.DownMin},
+			{"max download speed", &limits.DownMax},
+		}
+		for _, prompt := range prompts {
+			fmt.Fprintf(env.Out, "Enter %s (kbps) [Default %d]: ", prompt.text, *prompt.value)
+			*prompt.value, err = GetIntInput(env.In, *prompt.value)
+			if err != nil {
+				return NEXT, err
+			}
+		}
+
+		enabled := slot.Entry.Enabled
+		fmt.Fprintf(env.Out, "Enabled (y/n) [Default %s]: ", yesNo(enabled))
+		input, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		switch ToLowerCaseChar(input) {
+		case "y":
+			enabled = true
+		case "n":
+			enabled = false
+		}
+
+		err = env.Router.UpdateSlotContext(env.Context, slotId, limits, enabled)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "slot updated")
+		return NEXT, nil
+	},
+}
+
+var ActionToggleSlot = &Action{
+	Name:            "Enable/disable slot",
+	RequiresContext: []string{"slotId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		slotId, exists := env.Ctx["slotId"]
+		if !exists {
+			return NEXT, fmt.Errorf("slot id not provided")
+		}
+		slot, err := env.Router.GetSlotContext(env.Context, slotId)
+		if err != nil {
+			return NEXT, err
+		}
+
+		enabled := !slot.Slot.Enabled
+		err = env.Router.SetSlotEnabledContext(env.Context, slotId, enabled)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		if enabled {
+			fmt.Fprintln(env.Out, "slot enabled")
+		} else {
+			fmt.Fprintln(env.Out, "slot disabled, its address range stays reserved")
+		}
+		return NEXT, nil
+	},
+}
+
+var ActionResizeSlot = &Action{
+	Name:            "Resize slot",
+	RequiresContext: []string{"slotId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		slotId, exists := env.Ctx["slotId"]
+		if !exists {
+			return NEXT, fmt.Errorf("slot id not provided")
+		}
+
+		fmt.Fprintf(env.Out, "Enter new number of devices: ")
+		num, err := GetIntInput(env.In, 0)
+		if err != nil {
+			return NEXT, err
+		}
+
+		err = env.Router.ResizeSlotContext(env.Context, slotId, num)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "slot resized")
+		return NEXT, nil
+	},
+}
+
+var ActionMoveSlot = &Action{
+	Name:            "Move slot",
+	RequiresContext: []string{"slotId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		slotId, exists := env.Ctx["slotId"]
+		if !exists {
+			return NEXT, fmt.Errorf("slot id not provided")
+		}
+
+		fmt.Fprintf(env.Out, "Enter new start IP: ")
+		startIP, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+
+		err = env.Router.MoveSlotContext(env.Context, slotId, startIP)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "slot moved")
+		return NEXT, nil
+	},
+}
+
 var ActionListAvailableSlots = &Action{
 	Name: "List available bandwidth slots",
 	Action: func(env *core.Env) (Navigation, error) {
-		slots, err := env.Router.GetAvailableBandwidthSlots(true)
+		slots, err := env.Router.GetAvailableBandwidthSlotsContext(env.Context, true)
 		if err != nil {
 			return NEXT, err
 		}
@@ -436,6 +848,11 @@ var RootActionManageDevices = &Action{
 var ActionListDevices = &Action{
 	Name: "List devices",
 	Children: []*Action{
+		ActionAssignDeviceSlot,
+		ActionReassignDevice,
+		ActionEditDeviceAlias,
+		ActionEditDeviceInfo,
+		ActionChangeDeviceMac,
 		ActionDeregisterDevice,
 	},
 	Action: func(env *core.Env) (Navigation, error) {
@@ -451,9 +868,9 @@ var ActionListDevices = &Action{
 		for {
 			if showList {
 				if userIdProvided && userId != 0 {
-					devices, err = env.Store.DeviceStore.ReadManyByUserId(userId, pageSize, pageNumber)
+					devices, err = env.Store.DeviceStore.ReadManyByUserIdContext(env.Context, userId, pageSize, pageNumber)
 				} else {
-					devices, err = env.Store.DeviceStore.ReadMany(pageSize, pageNumber)
+					devices, err = env.Store.DeviceStore.ReadManyContext(env.Context, pageSize, pageNumber)
 				}
 
 				if err != nil {
@@ -472,7 +889,7 @@ var ActionListDevices = &Action{
 					} else {
 						details = fmt.Sprintf("%s\t\t%s", device.Alias, user.Name)
 					}
-					dataRows[i] = []string{device.Mac, details}
+					dataRows[i] = []string{device.Mac, details, device.Type, strings.Join(device.Tags, ",")}
 				}
 				err = PrintTable(env.Out, dataRows, true, 3)
 				if err != nil {
@@ -482,12 +899,17 @@ var ActionListDevices = &Action{
 				fmt.Fprintln(env.Out, "no more users found")
 			}
 
-			fmt.Fprintf(env.Out, "\nSelect device by number or scroll with n(ext)/p(revious)/q(uit): ")
+			fmt.Fprintf(env.Out, "\nSelect device by number, scroll with n(ext)/p(revious), search with / or q(uit): ")
 			choice, err := GetInput(env.In)
 			if err != nil {
 				return NEXT, err
 			}
 			switch choice {
+			case "/":
+				if err = searchDevices(env); err != nil {
+					return NEXT, err
+				}
+				showList = true
 			case "n":
 				if len(devices) == pageSize {
 					pageNumber += 1
@@ -531,7 +953,7 @@ var ActionShowConnectedDevices = &Action{
 			err        error
 		)
 
-		stats, devices, err := env.Router.GetConnectedDevices()
+		stats, devices, err := env.Router.GetConnectedDevicesContext(env.Context)
 		if err != nil {
 			return NEXT, err
 		}
@@ -613,7 +1035,7 @@ var ActionExportARPBindings = &Action{
 			err      error
 		)
 
-		bindings, err = env.Router.GetIpMacBindings()
+		bindings, err = env.Router.GetIpMacBindingsContext(env.Context)
 		if err != nil {
 			return NEXT, err
 		}
@@ -638,7 +1060,7 @@ var ActionExportDhcpAddressReservations = &Action{
 			err          error
 		)
 
-		reservations, err = env.Router.GetAddressReservations()
+		reservations, err = env.Router.GetAddressReservationsContext(env.Context)
 		if err != nil {
 			return NEXT, err
 		}
@@ -686,7 +1108,7 @@ var ActionRegisterDevice = &Action{
 				return NEXT, err
 			}
 
-			err = env.Router.RegisterDevice(mac, alias, slotId, userId)
+			err = env.Router.RegisterDeviceContext(env.Context, mac, alias, slotId, userId)
 			if err != nil {
 				return NEXT, err
 			}
@@ -697,8 +1119,8 @@ var ActionRegisterDevice = &Action{
 	},
 }
 
-var ActionDeregisterDevice = &Action{
-	Name:            "Deregister device",
+var ActionAssignDeviceSlot = &Action{
+	Name:            "Give device its own bandwidth entry",
 	RequiresContext: []string{"deviceId"},
 	Action: func(env *core.Env) (Navigation, error) {
 		deviceId, exists := env.Ctx["deviceId"]
@@ -706,31 +1128,269 @@ var ActionDeregisterDevice = &Action{
 			return NEXT, fmt.Errorf("device id not provided")
 		}
 
-		err := env.Router.DeregisterDevice(deviceId)
+		fmt.Fprintf(env.Out, "Enter IP address, or leave empty to pick a free one: ")
+		ip, err := GetInput(env.In)
 		if err != nil {
 			return NEXT, err
 		}
 
-		fmt.Fprintln(env.Out, "Device deregistered")
-		delete(env.Ctx, "deviceId")
-		return BACK, nil
-	},
-}
+		limits := core.SlotLimits{UpMin: 50, UpMax: 1000, DownMin: 50, DownMax: 1000}
+		prompts := []struct {
+			text  string
+			value *int
+		}{
+			{"min upload speed", &limits.UpMin},
+			{"max upload speed", &limits.UpMax},
+			{"min download speed", &limits.DownMin},
+			{"max download speed", &limits.DownMax},
+		}
+		for _, prompt := range prompts {
+			fmt.Fprintf(env.Out, "Enter %s (kbps) [Default %d]: ", prompt.text, *prompt.value)
+			*prompt.value, err = GetIntInput(env.In, *prompt.value)
+			if err != nil {
+				return NEXT, err
+			}
+		}
 
-var RootActionManageInternetAccess = &Action{
-	Name: "Manage internet access",
-	Children: []*Action{
-		ActionShowConnectedDevices,
-		ActionListBlockedDevices,
-		ActionBlockDevice,
-		ActionUnblockDevice,
+		slot, err := env.Router.AssignDeviceSlotContext(env.Context, deviceId, ip, limits)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "device now uses %s with slot %d\n", slot.StartIp, slot.Id)
+		return NEXT, nil
 	},
 }
 
-var ActionListBlockedDevices = &Action{
-	Name: "Show blocked devices",
+var ActionReassignDevice = &Action{
+	Name:            "Move device to another user or slot",
+	RequiresContext: []string{"deviceId"},
 	Action: func(env *core.Env) (Navigation, error) {
-		devices, err := env.Router.GetBlockedDevices()
+		deviceId, exists := env.Ctx["deviceId"]
+		if !exists {
+			return NEXT, fmt.Errorf("device id not provided")
+		}
+		device, err := env.Store.DeviceStore.ReadContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+		user, err := device.GetUser(env.Store.UserStore)
+		if err != nil {
+			return NEXT, err
+		}
+
+		fmt.Fprintf(env.Out, "Enter name of the new user [Default %s]: ", user.Name)
+		name, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		if name != "" {
+			user, err = env.Store.UserStore.ReadByNameContext(env.Context, name)
+			if err != nil {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+		}
+
+		userSlots, err := env.Router.GetUserSlotsContext(env.Context, user.Id, 100, 1)
+		if err != nil {
+			return NEXT, err
+		}
+		slots := make([]core.UserSlot, 0, len(userSlots))
+		for _, slot := range userSlots {
+			if slot.Slot.DeviceId == 0 {
+				slots = append(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			fmt.Fprintf(env.Out, "user '%s' has no bandwidth slots\n", user.Name)
+			return REPEAT, nil
+		}
+		dataRows := make([][]string, len(slots))
+		for i, slot := range slots {
+			dataRows[i] = []string{fmt.Sprintf("%s - %s", slot.Entry.StartIp, slot.Entry.EndIp)}
+		}
+		if err = PrintTable(env.Out, dataRows, true, 2); err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "Select slot by number: ")
+		choice, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		position, err := GetChoice(choice, len(slots))
+		if err == ErrInvalidChoice {
+			fmt.Fprintln(env.Out, "invalid choice")
+			return REPEAT, nil
+		}
+
+		err = env.Router.ReassignDeviceContext(env.Context, deviceId, user.Id, slots[position].Slot.Id)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "device '%s' moved to user '%s'\n", device.Alias, user.Name)
+		return NEXT, nil
+	},
+}
+
+var ActionEditDeviceAlias = &Action{
+	Name:            "Edit device alias",
+	RequiresContext: []string{"deviceId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		deviceId, exists := env.Ctx["deviceId"]
+		if !exists {
+			return NEXT, fmt.Errorf("device id not provided")
+		}
+		device, err := env.Store.DeviceStore.ReadContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "Enter alias [Default %s]: ", device.Alias)
+		alias, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		if alias == "" {
+			return REPEAT, nil
+		}
+		if err = env.Router.SetDeviceAliasContext(env.Context, deviceId, alias); err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "alias updated")
+		return REPEAT, nil
+	},
+}
+
+var ActionEditDeviceInfo = &Action{
+	Name:            "Edit device type, vendor, notes and tags",
+	RequiresContext: []string{"deviceId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		deviceId, exists := env.Ctx["deviceId"]
+		if !exists {
+			return NEXT, fmt.Errorf("device id not provided")
+		}
+		device, err := env.Store.DeviceStore.ReadContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+		info := core.DeviceInfo{Type: device.Type, Vendor: device.Vendor, Notes: device.Notes}
+		prompts := []struct {
+			label string
+			value *string
+		}{
+			{"Type, e.g. phone, laptop or iot", &info.Type},
+			{"Vendor", &info.Vendor},
+			{"Notes", &info.Notes},
+		}
+		for _, prompt := range prompts {
+			*prompt.value, err = promptText(env, prompt.label, *prompt.value)
+			if err != nil {
+				return NEXT, err
+			}
+		}
+		tags, err := promptText(env, "Tags, comma separated", strings.Join(device.Tags, ","))
+		if err != nil {
+			return NEXT, err
+		}
+		info.Tags = core.ParseTags(tags)
+
+		if err = env.Router.SetDeviceInfoContext(env.Context, deviceId, info); err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "device updated")
+		return REPEAT, nil
+	},
+}
+
+var ActionChangeDeviceMac = &Action{
+	Name:            "Change device MAC address",
+	RequiresContext: []string{"deviceId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		deviceId, exists := env.Ctx["deviceId"]
+		if !exists {
+			return NEXT, fmt.Errorf("device id not provided")
+		}
+		device, err := env.Store.DeviceStore.ReadContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "Enter MAC address [Current %s]: ", device.Mac)
+		mac, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		if mac == "" {
+			return REPEAT, nil
+		}
+		err = env.Router.ChangeDeviceMacContext(env.Context, deviceId, mac)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "MAC address updated")
+		return REPEAT, nil
+	},
+}
+
+var ActionDeregisterDevice = &Action{
+	Name:            "Deregister device",
+	RequiresContext: []string{"deviceId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		deviceId, exists := env.Ctx["deviceId"]
+		if !exists {
+			return NEXT, fmt.Errorf("device id not provided")
+		}
+
+		preview, err := env.Router.PreviewDeregisterDeviceContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+		confirmed, err := ConfirmRemoval(env, preview)
+		if err != nil {
+			return NEXT, err
+		}
+		if !confirmed {
+			fmt.Fprintln(env.Out, "cancelled")
+			return REPEAT, nil
+		}
+
+		err = env.Router.DeregisterDeviceContext(env.Context, deviceId)
+		if err != nil {
+			return NEXT, err
+		}
+
+		fmt.Fprintln(env.Out, "Device deregistered")
+		delete(env.Ctx, "deviceId")
+		return BACK, nil
+	},
+}
+
+var RootActionManageInternetAccess = &Action{
+	Name: "Manage internet access",
+	Children: []*Action{
+		ActionShowConnectedDevices,
+		ActionListBlockedDevices,
+		ActionBlockDevice,
+		ActionUnblockDevice,
+		ActionBlockTag,
+		ActionUnblockTag,
+	},
+}
+
+var ActionListBlockedDevices = &Action{
+	Name: "Show blocked devices",
+	Action: func(env *core.Env) (Navigation, error) {
+		devices, err := env.Router.GetBlockedDevicesContext(env.Context)
 		if err != nil {
 			return NEXT, err
 		}
@@ -766,8 +1426,12 @@ var ActionBlockDevice = &Action{
 			return NEXT, nil
 		}
 
-		err = env.Router.BlockDevice(mac)
-		return NEXT, err
+		err = env.Router.BlockDeviceContext(env.Context, mac)
+		if err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "device '%s' blocked\n", mac)
+		return NEXT, nil
 	},
 }
 
@@ -780,7 +1444,7 @@ var ActionUnblockDevice = &Action{
 			return NEXT, nil
 		}
 
-		err = env.Router.UnblockDevice(mac)
+		err = env.Router.UnblockDeviceContext(env.Context, mac)
 		if err != nil {
 			return NEXT, err
 		}
@@ -790,6 +1454,347 @@ var ActionUnblockDevice = &Action{
 	},
 }
 
+var ActionBlockTag = &Action{
+	Name: "Block devices by tag",
+	Action: func(env *core.Env) (Navigation, error) {
+		return setTagBlocked(env, true)
+	},
+}
+
+var ActionUnblockTag = &Action{
+	Name: "Unblock devices by tag",
+	Action: func(env *core.Env) (Navigation, error) {
+		return setTagBlocked(env, false)
+	},
+}
+
+func setTagBlocked(env *core.Env, blocked bool) (Navigation, error) {
+	tags, err := env.Router.GetTagsContext(env.Context)
+	if err != nil {
+		return NEXT, err
+	}
+	if len(tags) == 0 {
+		fmt.Fprintln(env.Out, "no tagged devices found")
+		return NEXT, nil
+	}
+	names := make([]string, 0, len(tags))
+	for tag, count := range tags {
+		names = append(names, fmt.Sprintf("%s (%d)", tag, count))
+	}
+	sort.Strings(names)
+	fmt.Fprintf(env.Out, "Tags: %s\n", strings.Join(names, ", "))
+	fmt.Fprintf(env.Out, "Enter tag: ")
+	tag, err := GetInput(env.In)
+	if err != nil || tag == "" {
+		return NEXT, err
+	}
+
+	changed, err := env.Router.SetTagBlockedContext(env.Context, tag, blocked)
+	if err != nil {
+		if err, ok := err.(*core.SoftError); ok {
+			fmt.Fprintln(env.Out, err.Error())
+			return REPEAT, nil
+		}
+		return NEXT, err
+	}
+	state := "unblocked"
+	if blocked {
+		state = "blocked"
+	}
+	fmt.Fprintf(env.Out, "%d devices %s\n", changed, state)
+	return NEXT, nil
+}
+
+var RootActionManageGroups = &Action{
+	Name: "Manage groups",
+	Children: []*Action{
+		ActionCreateGroup,
+		ActionListGroups,
+	},
+}
+
+var ActionCreateGroup = &Action{
+	Name: "Create a group",
+	Action: func(env *core.Env) (Navigation, error) {
+		fmt.Fprintf(env.Out, "Name: ")
+		name, err := GetInput(env.In)
+		if err != nil {
+			return NEXT, err
+		}
+		group, err := env.Router.CreateGroupContext(env.Context, name)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "group '%s' created\n", group.Name)
+		return REPEAT, nil
+	},
+}
+
+var ActionListGroups = &Action{
+	Name: "List groups",
+	Children: []*Action{
+		ActionShowGroupMembers,
+		ActionAddGroupMember,
+		ActionRemoveGroupMember,
+		ActionBlockGroup,
+		ActionUnblockGroup,
+		ActionSetGroupPlan,
+		ActionAddGroupSchedule,
+		ActionDeleteGroup,
+	},
+	Action: func(env *core.Env) (Navigation, error) {
+		groups, err := env.Router.GetGroupsContext(env.Context)
+		if err != nil {
+			return NEXT, err
+		}
+		if len(groups) == 0 {
+			fmt.Fprintln(env.Out, "no groups found")
+			return REPEAT, nil
+		}
+		dataRows := make([][]string, len(groups))
+		for i, group := range groups {
+			dataRows[i] = []string{group.Name, fmt.Sprintf("%d members", group.Members)}
+		}
+		if err = PrintTable(env.Out, dataRows, true, 2); err != nil {
+			return NEXT, err
+		}
+
+		for {
+			fmt.Fprintf(env.Out, "\nSelect group by number or q(uit): ")
+			choice, err := GetInput(env.In)
+			if err != nil {
+				return NEXT, err
+			}
+			if choice == "q" {
+				return REPEAT, nil
+			}
+			position, err := GetChoice(choice, len(groups))
+			if err == ErrInvalidChoice {
+				fmt.Fprintln(env.Out, "invalid choice. try again")
+				continue
+			}
+			env.Ctx.Set("groupId", groups[position].Id)
+			return NEXT, nil
+		}
+	},
+}
+
+var ActionShowGroupMembers = &Action{
+	Name:            "Show members",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		groupId, exists := env.Ctx["groupId"]
+		if !exists {
+			return NEXT, fmt.Errorf("group id not provided")
+		}
+		members, err := env.Router.GetGroupMembersContext(env.Context, groupId)
+		if err != nil {
+			return NEXT, err
+		}
+		if len(members) == 0 {
+			fmt.Fprintln(env.Out, "no members")
+			return REPEAT, nil
+		}
+		dataRows := make([][]string, len(members))
+		for i, member := range members {
+			dataRows[i] = []string{member.Name, member.Contact}
+		}
+		return REPEAT, PrintTable(env.Out, dataRows, true, 2)
+	},
+}
+
+var ActionAddGroupMember = &Action{
+	Name:            "Add member",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return changeGroupMember(env, true)
+	},
+}
+
+var ActionRemoveGroupMember = &Action{
+	Name:            "Remove member",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return changeGroupMember(env, false)
+	},
+}
+
+func changeGroupMember(env *core.Env, add bool) (Navigation, error) {
+	groupId, exists := env.Ctx["groupId"]
+	if !exists {
+		return NEXT, fmt.Errorf("group id not provided")
+	}
+	fmt.Fprintf(env.Out, "Enter user name: ")
+	name, err := GetInput(env.In)
+	if err != nil || name == "" {
+		return REPEAT, err
+	}
+	user, err := env.Store.UserStore.ReadByNameContext(env.Context, name)
+	if err != nil {
+		fmt.Fprintln(env.Out, err.Error())
+		return REPEAT, nil
+	}
+	if add {
+		err = env.Router.AddGroupMemberContext(env.Context, groupId, user.Id)
+	} else {
+		err = env.Router.RemoveGroupMemberContext(env.Context, groupId, user.Id)
+	}
+	if err != nil {
+		return NEXT, err
+	}
+	if add {
+		fmt.Fprintf(env.Out, "'%s' added\n", user.Name)
+	} else {
+		fmt.Fprintf(env.Out, "'%s' removed\n", user.Name)
+	}
+	return REPEAT, nil
+}
+
+var ActionBlockGroup = &Action{
+	Name:            "Block devices of members",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return setGroupBlocked(env, true)
+	},
+}
+
+var ActionUnblockGroup = &Action{
+	Name:            "Unblock devices of members",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		return setGroupBlocked(env, false)
+	},
+}
+
+func setGroupBlocked(env *core.Env, blocked bool) (Navigation, error) {
+	groupId, exists := env.Ctx["groupId"]
+	if !exists {
+		return NEXT, fmt.Errorf("group id not provided")
+	}
+	changed, err := env.Router.SetGroupBlockedContext(env.Context, groupId, blocked)
+	if err != nil {
+		if err, ok := err.(*core.SoftError); ok {
+			fmt.Fprintln(env.Out, err.Error())
+			return REPEAT, nil
+		}
+		return NEXT, err
+	}
+	state := "unblocked"
+	if blocked {
+		state = "blocked"
+	}
+	fmt.Fprintf(env.Out, "%d devices %s\n", changed, state)
+	return REPEAT, nil
+}
+
+var ActionSetGroupPlan = &Action{
+	Name:            "Apply plan to slots of members",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		groupId, exists := env.Ctx["groupId"]
+		if !exists {
+			return NEXT, fmt.Errorf("group id not provided")
+		}
+		plan, err := choosePlan(env)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		if plan == nil {
+			return REPEAT, nil
+		}
+		changed, err := env.Router.SetGroupPlanContext(env.Context, groupId, plan.Name)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "%d slots now use plan '%s'\n", changed, plan.Name)
+		return REPEAT, nil
+	},
+}
+
+var ActionAddGroupSchedule = &Action{
+	Name:            "Add schedule to slots of members",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		groupId, exists := env.Ctx["groupId"]
+		if !exists {
+			return NEXT, fmt.Errorf("group id not provided")
+		}
+		schedule := storage.SlotSchedule{UpMin: 50, UpMax: 1000, DownMin: 50, DownMax: 1000}
+		texts := []struct {
+			text  string
+			value *string
+		}{
+			{"Enter days, e.g. mon,tue, or leave empty for every day: ", &schedule.Days},
+			{"Enter start time (HH:MM): ", &schedule.Start},
+			{"Enter end time (HH:MM): ", &schedule.End},
+		}
+		var err error
+		for _, prompt := range texts {
+			fmt.Fprint(env.Out, prompt.text)
+			*prompt.value, err = GetInput(env.In)
+			if err != nil {
+				return NEXT, err
+			}
+		}
+		limits := []struct {
+			text  string
+			value *int
+		}{
+			{"min upload speed", &schedule.UpMin},
+			{"max upload speed", &schedule.UpMax},
+			{"min download speed", &schedule.DownMin},
+			{"max download speed", &schedule.DownMax},
+		}
+		for _, prompt := range limits {
+			fmt.Fprintf(env.Out, "Enter %s (kbps) [Default %d]: ", prompt.text, *prompt.value)
+			*prompt.value, err = GetIntInput(env.In, *prompt.value)
+			if err != nil {
+				return NEXT, err
+			}
+		}
+
+		schedules, err := env.Router.AddGroupScheduleContext(env.Context, groupId, schedule)
+		if err != nil {
+			if err, ok := err.(*core.SoftError); ok {
+				fmt.Fprintln(env.Out, err.Error())
+				return REPEAT, nil
+			}
+			return NEXT, err
+		}
+		fmt.Fprintf(env.Out, "schedule added to %d slots\n", len(schedules))
+		return REPEAT, nil
+	},
+}
+
+var ActionDeleteGroup = &Action{
+	Name:            "Delete group",
+	RequiresContext: []string{"groupId"},
+	Action: func(env *core.Env) (Navigation, error) {
+		groupId, exists := env.Ctx["groupId"]
+		if !exists {
+			return NEXT, fmt.Errorf("group id not provided")
+		}
+		if err := env.Router.DeleteGroupContext(env.Context, groupId); err != nil {
+			return NEXT, err
+		}
+		fmt.Fprintln(env.Out, "group deleted, its members are kept")
+		delete(env.Ctx, "groupId")
+		return BACK, nil
+	},
+}
+
 var ActionQuit = &Action{
 	Name: "Quit",
 	Action: func(env *core.Env) (Navigation, error) {
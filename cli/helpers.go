@@ -14,7 +14,9 @@ import (
 	"text/tabwriter"
 	"unicode"
 
+	"github.com/omushpapa/routerman/core"
 	"github.com/omushpapa/tplinkapi"
+	"golang.org/x/term"
 )
 
 var macAddressRegex = regexp.MustCompile(`^([0-9A-Fa-f]{2}[:-]){5}([0-9A-Fa-f]{2})$`)
@@ -33,6 +35,20 @@ func GetInput(in io.Reader) (string, error) {
 	return input, nil
 }
 
+// GetSecretInput reads a line without echoing it when reading from a
+// terminal.
+func GetSecretInput(in io.Reader, out io.Writer) (string, error) {
+	if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		secret, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(secret)), nil
+	}
+	return GetInput(in)
+}
+
 func GetChoiceInput(in io.Reader, max int) (int, error) {
 	input, err := GetInput(in)
 	if err != nil {
@@ -163,3 +179,32 @@ func ExportBindings(bindings []tplinkapi.ClientReservation, filename string) err
 
 	return nil
 }
+
+func printRemovalItems(out io.Writer, title string, items []string) {
+	fmt.Fprintf(out, "%s:\n", title)
+	if len(items) == 0 {
+		fmt.Fprintln(out, "  (none)")
+	}
+	for _, item := range items {
+		fmt.Fprintf(out, "  - %s\n", item)
+	}
+}
+
+func ConfirmRemoval(env *core.Env, preview core.RemovalPreview) (bool, error) {
+	fmt.Fprintln(env.Out, "\nThe following will be removed")
+	printRemovalItems(env.Out, "Router entries", preview.RouterEntries)
+	printRemovalItems(env.Out, "Database rows", preview.DbRows)
+
+	for {
+		fmt.Fprintf(env.Out, "Proceed? (y/n): ")
+		input, err := GetCharChoice(env.In, []string{"y", "n"})
+		if err == ErrInvalidChoice {
+			fmt.Fprintln(env.Out, "Invalid choice. Try again")
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return input == "y", nil
+	}
+}
@@ -1,7 +1,11 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 
@@ -61,7 +65,15 @@ func RunMenuActions(env *core.Env, actions []*Action) (Navigation, error) {
 		}
 
 		if action.Action != nil {
-			navigation, err = action.Action(env)
+			navigation, err = runAction(env, action)
+			if errors.Is(err, context.Canceled) {
+				fmt.Fprintln(env.Out, "\ncancelled")
+				continue
+			}
+			if errors.Is(err, core.ErrRouterUnreachable) {
+				fmt.Fprintf(env.Out, "%v\n", err)
+				continue
+			}
 			if err != nil {
 				return NEXT, err
 			}
@@ -94,6 +106,20 @@ func RunMenuActions(env *core.Env, actions []*Action) (Navigation, error) {
 	return NEXT, nil
 }
 
+// runAction cancels the action's context on Ctrl-C instead of exiting, so
+// that a hung router call returns to the menu.
+func runAction(env *core.Env, action *Action) (Navigation, error) {
+	parent := env.Context
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt)
+	defer stop()
+
+	env.Context = ctx
+	defer func() {
+		env.Context = parent
+	}()
+	return action.Action(env)
+}
+
 func (action Action) GetValidChildren(ctx core.Context) []*Action {
 	actions := make([]*Action, 0)
 
@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omushpapa/routerman/core"
+)
+
+func formatPercent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "y"
+	}
+	return "n"
+}
+
+func PrintIpamReport(out io.Writer, report core.IpamReport) error {
+	summary := [][]string{
+		{"Network", report.Network},
+		{"Router", report.RouterIP},
+		{"DHCP pool", fmt.Sprintf(
+			"%s - %s (%d of %d in use, %s)",
+			report.PoolStart, report.PoolEnd, report.PoolUsed, report.PoolSize, formatPercent(report.PoolUtilisation()),
+		)},
+		{"Bandwidth ranges", fmt.Sprintf("%d addresses", report.BandwidthAddresses)},
+		{"Free", fmt.Sprintf("%d of %d addresses", report.FreeAddresses, report.UsableAddresses)},
+		{"Utilisation", formatPercent(report.Utilisation())},
+	}
+	if err := PrintTable(out, summary, false, 0); err != nil {
+		return err
+	}
+
+	segments := [][]string{{"START", "END", "ADDRESSES", "USE"}}
+	for _, segment := range report.Segments {
+		use := "free"
+		if !segment.IsFree() {
+			use = strings.Join(segment.Uses, ", ")
+		}
+		segments = append(segments, []string{segment.Start, segment.End, strconv.Itoa(segment.Size), use})
+	}
+	fmt.Fprintln(out)
+	if err := PrintTable(out, segments, false, 0); err != nil {
+		return err
+	}
+
+	assignments := [][]string{{"IP", "MAC", "DEVICE", "USER", "RESERVED", "ONLINE"}}
+	for _, assignment := range report.Assignments {
+		assignments = append(assignments, []string{
+			assignment.IP,
+			assignment.Mac,
+			assignment.Alias,
+			assignment.User,
+			yesNo(assignment.Reserved),
+			yesNo(assignment.Online),
+		})
+	}
+	fmt.Fprintln(out)
+	if err := PrintTable(out, assignments, false, 0); err != nil {
+		return err
+	}
+
+	if report.PoolNearlyExhausted() {
+		fmt.Fprintf(
+			out, "\nwarning: DHCP pool is %s used, new devices may not get an address\n",
+			formatPercent(report.PoolUtilisation()),
+		)
+	}
+	return nil
+}
+
+// SlotRow describes a slot for tables listing slots of several users.
+func SlotRow(env *core.Env, slot core.UserSlot) []string {
+	var userName string
+	if user, err := env.Store.UserStore.ReadContext(env.Context, slot.Slot.UserId); err == nil {
+		userName = user.Name
+	}
+	if slot.Slot.DeviceId != 0 {
+		if device, err := env.Store.DeviceStore.ReadContext(env.Context, slot.Slot.DeviceId); err == nil {
+			userName = fmt.Sprintf("%s (%s)", userName, device.Alias)
+		}
+	}
+	planName := "-"
+	if slot.Slot.PlanId != 0 {
+		if plan, err := env.Store.PlanStore.ReadContext(env.Context, slot.Slot.PlanId); err == nil {
+			planName = plan.Name
+		}
+	}
+	entry := slot.Entry
+	return []string{
+		strconv.Itoa(slot.Slot.Id),
+		userName,
+		fmt.Sprintf("%s - %s", entry.StartIp, entry.EndIp),
+		fmt.Sprintf("%d/%d", entry.UpMin, entry.UpMax),
+		fmt.Sprintf("%d/%d", entry.DownMin, entry.DownMax),
+		yesNo(entry.Enabled),
+		planName,
+	}
+}
+
+func PrintSearchResults(out io.Writer, results []core.SearchResult) error {
+	if len(results) == 0 {
+		fmt.Fprintln(out, "nothing found")
+		return nil
+	}
+	dataRows := [][]string{{"ID", "DEVICE", "MAC", "USER", "IP", "BLOCKED"}}
+	for _, result := range results {
+		// A user without devices who matched by name.
+		if result.DeviceId == 0 {
+			dataRows = append(dataRows, []string{"-", "-", "-", result.User, "-", "-"})
+			continue
+		}
+		ip := result.IP
+		if ip == "" {
+			ip = "-"
+		}
+		dataRows = append(dataRows, []string{
+			strconv.Itoa(result.DeviceId),
+			result.Alias,
+			result.Mac,
+			result.User,
+			ip,
+			yesNo(result.Blocked),
+		})
+	}
+	return PrintTable(out, dataRows, false, 0)
+}
+
+func PrintUserDetails(out io.Writer, details core.UserDetails) error {
+	user := [][]string{{"User", details.User.Name}}
+	if details.User.Contact != "" {
+		user = append(user, []string{"Contact", details.User.Contact})
+	}
+	if details.User.Notes != "" {
+		user = append(user, []string{"Notes", details.User.Notes})
+	}
+	if len(details.Groups) > 0 {
+		groups := make([]string, len(details.Groups))
+		for i, group := range details.Groups {
+			groups[i] = group.Name
+		}
+		user = append(user, []string{"Groups", strings.Join(groups, ", ")})
+	}
+	user = append(user, []string{"Added", formatTime(details.User.CreatedAt)})
+	if err := PrintTable(out, user, false, 0); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+
+	devices := [][]string{{"ID", "DEVICE", "MAC", "TYPE", "TAGS", "IP", "ONLINE", "BLOCKED"}}
+	for _, device := range details.Devices {
+		ip := device.IP
+		if ip == "" {
+			ip = "-"
+		}
+		devices = append(devices, []string{
+			strconv.Itoa(device.Device.Id),
+			device.Device.Alias,
+			device.Device.Mac,
+			orDash(device.Device.Type),
+			orDash(strings.Join(device.Device.Tags, ",")),
+			ip,
+			yesNo(device.Online),
+			yesNo(device.Blocked),
+		})
+	}
+	if len(details.Devices) == 0 {
+		fmt.Fprintln(out, "no devices")
+	} else if err := PrintTable(out, devices, false, 0); err != nil {
+		return err
+	}
+
+	slots := [][]string{{"ID", "RANGE", "UP", "DOWN", "ENABLED", "ADDRESSES"}}
+	for _, slot := range details.Slots {
+		limits := slot.Limits()
+		slots = append(slots, []string{
+			strconv.Itoa(slot.Slot.Id),
+			fmt.Sprintf("%s - %s", slot.Entry.StartIp, slot.Entry.EndIp),
+			fmt.Sprintf("%d/%d", limits.UpMin, limits.UpMax),
+			fmt.Sprintf("%d/%d", limits.DownMin, limits.DownMax),
+			yesNo(slot.Slot.Enabled),
+			fmt.Sprintf("%d of %d (%s)", slot.Used, slot.Size, formatPercent(slot.Utilisation())),
+		})
+	}
+	fmt.Fprintln(out)
+	if len(details.Slots) == 0 {
+		fmt.Fprintln(out, "no bandwidth slots")
+		return nil
+	}
+	return PrintTable(out, slots, false, 0)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/config"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the resolved settings",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := config.Resolve(overrides)
+		if err != nil {
+			exitWithError(err)
+		}
+		dataRows := make([][]string, 0)
+		for _, setting := range settings.All() {
+			dataRows = append(dataRows, []string{setting.Name, setting.Display(), "(" + setting.Source + ")"})
+		}
+		if err = cli.PrintTable(os.Stdout, dataRows, false, 0); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/omushpapa/routerman/storage"
+	"github.com/spf13/cobra"
+)
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Manage devices",
+}
+
+var deviceListTag string
+
+var deviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List devices",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			var (
+				devices []storage.Device
+				err     error
+			)
+			if deviceListTag != "" {
+				devices, err = env.Router.GetTaggedDevicesContext(ctx, deviceListTag)
+			} else {
+				devices, err = env.Router.GetDevicesContext(ctx)
+			}
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"ID", "MAC", "ALIAS", "USER", "TYPE", "VENDOR", "TAGS", "ADDED"}}
+			for _, device := range devices {
+				var userName string
+				if user, err := env.Store.UserStore.ReadContext(ctx, device.UserId); err == nil {
+					userName = user.Name
+				}
+				dataRows = append(dataRows, []string{
+					strconv.Itoa(device.Id),
+					device.Mac,
+					device.Alias,
+					userName,
+					device.Type,
+					device.Vendor,
+					strings.Join(device.Tags, ","),
+					device.CreatedAt.Local().Format("2006-01-02"),
+				})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var deviceSlotFlags struct {
+	ip                             string
+	upMin, upMax, downMin, downMax int
+}
+
+var deviceSlotCmd = &cobra.Command{
+	Use:   "slot <device-id>",
+	Short: "Give a device a bandwidth entry of its own for a single address",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceId := parseId(args[0])
+		limits := core.SlotLimits{
+			UpMin:   deviceSlotFlags.upMin,
+			UpMax:   deviceSlotFlags.upMax,
+			DownMin: deviceSlotFlags.downMin,
+			DownMax: deviceSlotFlags.downMax,
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			slot, err := env.Router.AssignDeviceSlotContext(ctx, deviceId, deviceSlotFlags.ip, limits)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.Out, "device now uses %s with slot %d\n", slot.StartIp, slot.Id)
+			return nil
+		})
+	},
+}
+
+var deviceMoveSlotId int
+
+var deviceMoveCmd = &cobra.Command{
+	Use:   "move <device-id> <user-name>",
+	Short: "Give a device to another user, reserving an address in one of their slots",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			user, err := env.Store.UserStore.ReadByNameContext(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			slotId := deviceMoveSlotId
+			if slotId == 0 {
+				slots, err := env.Router.GetUserSlotsContext(ctx, user.Id, 100, 1)
+				if err != nil {
+					return err
+				}
+				for _, slot := range slots {
+					if slot.Slot.DeviceId != 0 {
+						continue
+					}
+					if slotId != 0 {
+						return fmt.Errorf("user '%s' has several slots, choose one with --slot", user.Name)
+					}
+					slotId = slot.Slot.Id
+				}
+				if slotId == 0 {
+					return fmt.Errorf("user '%s' has no bandwidth slots", user.Name)
+				}
+			}
+			return env.Router.ReassignDeviceContext(ctx, deviceId, user.Id, slotId)
+		})
+	},
+}
+
+var deviceAliasCmd = &cobra.Command{
+	Use:   "alias <device-id> <alias>",
+	Short: "Change the alias of a device",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			return env.Router.SetDeviceAliasContext(ctx, deviceId, args[1])
+		})
+	},
+}
+
+var deviceMacCmd = &cobra.Command{
+	Use:   "mac <device-id> <mac>",
+	Short: "Correct the MAC address of a device, moving its reservation and access control entries",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			return env.Router.ChangeDeviceMacContext(ctx, deviceId, args[1])
+		})
+	},
+}
+
+var deviceSetFlags struct {
+	deviceType, vendor, notes, tags string
+}
+
+var deviceSetCmd = &cobra.Command{
+	Use:   "set <device-id>",
+	Short: "Change the type, vendor, notes or tags of a device",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deviceId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			device, err := env.Store.DeviceStore.ReadContext(ctx, deviceId)
+			if err != nil {
+				return err
+			}
+			info := core.DeviceInfo{Type: device.Type, Vendor: device.Vendor, Notes: device.Notes, Tags: device.Tags}
+			if cmd.Flags().Changed("type") {
+				info.Type = deviceSetFlags.deviceType
+			}
+			if cmd.Flags().Changed("vendor") {
+				info.Vendor = deviceSetFlags.vendor
+			}
+			if cmd.Flags().Changed("notes") {
+				info.Notes = deviceSetFlags.notes
+			}
+			if cmd.Flags().Changed("tags") {
+				info.Tags = core.ParseTags(deviceSetFlags.tags)
+			}
+			return env.Router.SetDeviceInfoContext(ctx, deviceId, info)
+		})
+	},
+}
+
+var deviceTagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "List device tags",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			tags, err := env.Router.GetTagsContext(ctx)
+			if err != nil {
+				return err
+			}
+			names := make([]string, 0, len(tags))
+			for tag := range tags {
+				names = append(names, tag)
+			}
+			sort.Strings(names)
+			dataRows := [][]string{{"TAG", "DEVICES"}}
+			for _, tag := range names {
+				dataRows = append(dataRows, []string{tag, strconv.Itoa(tags[tag])})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+func deviceBlockCmd(blocked bool) *cobra.Command {
+	use, short, state := "block --tag <tag>", "Block internet access of every device with a tag", "blocked"
+	if !blocked {
+		use, short, state = "unblock --tag <tag>", "Unblock internet access of every device with a tag", "unblocked"
+	}
+	var tag string
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runWithEnv(func(ctx context.Context, env *core.Env) error {
+				changed, err := env.Router.SetTagBlockedContext(ctx, tag, blocked)
+				if err != nil {
+					if changed > 0 {
+						fmt.Fprintf(env.Out, "%d devices %s before the error\n", changed, state)
+					}
+					return err
+				}
+				fmt.Fprintf(env.Out, "%d devices %s\n", changed, state)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&tag, "tag", "", "Tag of the devices")
+	cmd.MarkFlagRequired("tag")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(deviceCmd)
+	deviceCmd.AddCommand(deviceListCmd)
+	deviceListCmd.Flags().StringVar(&deviceListTag, "tag", "", "Only list devices with this tag")
+	deviceCmd.AddCommand(deviceSetCmd)
+	deviceSetCmd.Flags().StringVar(&deviceSetFlags.deviceType, "type", "", "Kind of device, e.g. phone, laptop or iot")
+	deviceSetCmd.Flags().StringVar(&deviceSetFlags.vendor, "vendor", "", "Vendor of the device")
+	deviceSetCmd.Flags().StringVar(&deviceSetFlags.notes, "notes", "", "Free-form notes")
+	deviceSetCmd.Flags().StringVar(&deviceSetFlags.tags, "tags", "", "Comma separated tags, replacing the current ones")
+	deviceCmd.AddCommand(deviceTagsCmd)
+	deviceCmd.AddCommand(deviceBlockCmd(true))
+	deviceCmd.AddCommand(deviceBlockCmd(false))
+
+	deviceCmd.AddCommand(deviceAliasCmd)
+	deviceCmd.AddCommand(deviceMacCmd)
+	deviceCmd.AddCommand(deviceMoveCmd)
+	deviceMoveCmd.Flags().IntVar(&deviceMoveSlotId, "slot", 0, "Slot of the new user, needed when they have several")
+
+	deviceCmd.AddCommand(deviceSlotCmd)
+	deviceSlotCmd.Flags().StringVar(&deviceSlotFlags.ip, "ip", "", "Address for the device, a free one outside the DHCP pool when empty")
+	deviceSlotCmd.Flags().IntVar(&deviceSlotFlags.upMin, "up-min", 50, "Minimum upload speed in kbps")
+	deviceSlotCmd.Flags().IntVar(&deviceSlotFlags.upMax, "up-max", 1000, "Maximum upload speed in kbps")
+	deviceSlotCmd.Flags().IntVar(&deviceSlotFlags.downMin, "down-min", 50, "Minimum download speed in kbps")
+	deviceSlotCmd.Flags().IntVar(&deviceSlotFlags.downMax, "down-max", 1000, "Maximum download speed in kbps")
+}
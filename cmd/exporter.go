@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/omushpapa/routerman/config"
+	"github.com/omushpapa/routerman/exporter"
+	"github.com/spf13/cobra"
+)
+
+var listenAddress string
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve router metrics for Prometheus",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := config.Resolve(overrides)
+		if err != nil {
+			exitWithError(err)
+		}
+		db, err := openDatabase(settings)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer db.Close()
+
+		env, err := newEnv(settings, db, os.Stdin, os.Stderr)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer env.Router.Close()
+
+		server := &http.Server{
+			Addr:              listenAddress,
+			Handler:           exporter.New(env).Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		env.Logger.Info("serving metrics", "address", listenAddress)
+		if err = server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			exitWithError(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+	exporterCmd.Flags().StringVar(&listenAddress, "listen", ":9110", "Address to serve /metrics on")
+}
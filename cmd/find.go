@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/spf13/cobra"
+)
+
+var findCmd = &cobra.Command{
+	Use:   "find <term>",
+	Short: "Find devices by user name, alias, full or partial MAC address, or IP address",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			results, err := env.Router.FindDevicesContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return cli.PrintSearchResults(env.Out, results)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(findCmd)
+}
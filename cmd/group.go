@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/omushpapa/routerman/storage"
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage groups of users",
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List groups",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			groups, err := env.Router.GetGroupsContext(ctx)
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"ID", "NAME", "MEMBERS"}}
+			for _, group := range groups {
+				dataRows = append(dataRows, []string{strconv.Itoa(group.Id), group.Name, strconv.Itoa(group.Members)})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Create a group",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			group, err := env.Router.CreateGroupContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.Out, "group %d created\n", group.Id)
+			return nil
+		})
+	},
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a group, keeping its members",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			group, err := env.Router.GetGroupContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return env.Router.DeleteGroupContext(ctx, group.Id)
+		})
+	},
+}
+
+var groupMembersCmd = &cobra.Command{
+	Use:   "members <name>",
+	Short: "List the members of a group",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			group, err := env.Router.GetGroupContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			members, err := env.Router.GetGroupMembersContext(ctx, group.Id)
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"ID", "NAME", "CONTACT"}}
+			for _, member := range members {
+				dataRows = append(dataRows, []string{strconv.Itoa(member.Id), member.Name, member.Contact})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+func groupMemberCmd(add bool) *cobra.Command {
+	use, short := "join <group> <user-name>...", "Add users to a group"
+	if !add {
+		use, short = "leave <group> <user-name>...", "Remove users from a group"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			runWithEnv(func(ctx context.Context, env *core.Env) error {
+				group, err := env.Router.GetGroupContext(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				for _, name := range args[1:] {
+					user, err := env.Store.UserStore.ReadByNameContext(ctx, name)
+					if err != nil {
+						return err
+					}
+					if add {
+						err = env.Router.AddGroupMemberContext(ctx, group.Id, user.Id)
+					} else {
+						err = env.Router.RemoveGroupMemberContext(ctx, group.Id, user.Id)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+}
+
+func groupBlockCmd(blocked bool) *cobra.Command {
+	use, short, state := "block <group>", "Block internet access of every device of a group's members", "blocked"
+	if !blocked {
+		use, short, state = "unblock <group>", "Unblock internet access of every device of a group's members", "unblocked"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runWithEnv(func(ctx context.Context, env *core.Env) error {
+				group, err := env.Router.GetGroupContext(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				changed, err := env.Router.SetGroupBlockedContext(ctx, group.Id, blocked)
+				if err != nil {
+					if changed > 0 {
+						fmt.Fprintf(env.Out, "%d devices %s before the error\n", changed, state)
+					}
+					return err
+				}
+				fmt.Fprintf(env.Out, "%d devices %s\n", changed, state)
+				return nil
+			})
+		},
+	}
+}
+
+var groupPlanCmd = &cobra.Command{
+	Use:   "plan <group> <plan>",
+	Short: "Apply a plan to every slot of a group's members",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			group, err := env.Router.GetGroupContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			changed, err := env.Router.SetGroupPlanContext(ctx, group.Id, args[1])
+			if err != nil {
+				if changed > 0 {
+					fmt.Fprintf(env.Out, "%d slots updated before the error\n", changed)
+				}
+				return err
+			}
+			fmt.Fprintf(env.Out, "%d slots updated\n", changed)
+			return nil
+		})
+	},
+}
+
+var groupScheduleCmd = &cobra.Command{
+	Use:   "schedule <group>",
+	Short: "Add a schedule to every slot of a group's members",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		schedule := storage.SlotSchedule{
+			Days:    scheduleFlags.days,
+			Start:   scheduleFlags.from,
+			End:     scheduleFlags.to,
+			UpMin:   scheduleFlags.upMin,
+			UpMax:   scheduleFlags.upMax,
+			DownMin: scheduleFlags.downMin,
+			DownMax: scheduleFlags.downMax,
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			group, err := env.Router.GetGroupContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			schedules, err := env.Router.AddGroupScheduleContext(ctx, group.Id, schedule)
+			if err != nil {
+				if len(schedules) > 0 {
+					fmt.Fprintf(env.Out, "schedule added to %d slots before the error\n", len(schedules))
+				}
+				return err
+			}
+			fmt.Fprintf(env.Out, "schedule added to %d slots\n", len(schedules))
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupDeleteCmd)
+	groupCmd.AddCommand(groupMembersCmd)
+	groupCmd.AddCommand(groupMemberCmd(true))
+	groupCmd.AddCommand(groupMemberCmd(false))
+	groupCmd.AddCommand(groupBlockCmd(true))
+	groupCmd.AddCommand(groupBlockCmd(false))
+	groupCmd.AddCommand(groupPlanCmd)
+
+	groupCmd.AddCommand(groupScheduleCmd)
+	groupScheduleCmd.Flags().StringVar(&scheduleFlags.days, "days", "", "Comma separated days, e.g. mon,tue; every day when empty")
+	groupScheduleCmd.Flags().StringVar(&scheduleFlags.from, "from", "", "Start time HH:MM")
+	groupScheduleCmd.Flags().StringVar(&scheduleFlags.to, "to", "", "End time HH:MM, before the start time to run past midnight")
+	groupScheduleCmd.Flags().IntVar(&scheduleFlags.upMin, "up-min", 50, "Minimum upload speed in kbps")
+	groupScheduleCmd.Flags().IntVar(&scheduleFlags.upMax, "up-max", 1000, "Maximum upload speed in kbps")
+	groupScheduleCmd.Flags().IntVar(&scheduleFlags.downMin, "down-min", 50, "Minimum download speed in kbps")
+	groupScheduleCmd.Flags().IntVar(&scheduleFlags.downMax, "down-max", 1000, "Maximum download speed in kbps")
+	groupScheduleCmd.MarkFlagRequired("from")
+	groupScheduleCmd.MarkFlagRequired("to")
+}
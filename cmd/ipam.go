@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/spf13/cobra"
+)
+
+var ipamCmd = &cobra.Command{
+	Use:   "ipam",
+	Short: "Show how the LAN subnet is allocated",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			report, err := env.Router.GetIpamReportContext(ctx)
+			if err != nil {
+				return err
+			}
+			return cli.PrintIpamReport(env.Out, report)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ipamCmd)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/config"
+	"github.com/omushpapa/routerman/credentials"
+	"github.com/spf13/cobra"
+)
+
+var loginBackend string
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Store router credentials for the selected profile",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := config.Resolve(overrides)
+		if err != nil {
+			exitWithError(err)
+		}
+
+		plainPath, encryptedPath := settings.CredentialPaths()
+		var (
+			store credentials.Store
+			path  string
+		)
+		switch loginBackend {
+		case "encrypted":
+			path = encryptedPath
+			store = credentials.EncryptedFile{Path: path, Passphrase: getNewPassphrase}
+		case "file":
+			path = plainPath
+			store = credentials.File{Path: path}
+		default:
+			exitWithError(fmt.Errorf("unknown backend '%s'", loginBackend))
+		}
+
+		username := settings.Username.Value
+		fmt.Printf("Username [%s]: ", username)
+		input, err := cli.GetInput(os.Stdin)
+		if err != nil {
+			exitWithError(err)
+		}
+		if input != "" {
+			username = input
+		}
+		if username == "" {
+			exitWithError(fmt.Errorf("username is required"))
+		}
+
+		fmt.Printf("Password: ")
+		password, err := cli.GetSecretInput(os.Stdin, os.Stdout)
+		if err != nil {
+			exitWithError(err)
+		}
+		if password == "" {
+			exitWithError(fmt.Errorf("password is required"))
+		}
+
+		creds := credentials.Credentials{Username: username, Password: password}
+		if err = store.Save(creds); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("credentials for profile '%s' saved to '%s'\n", settings.Profile.Value, path)
+	},
+}
+
+func getNewPassphrase() (string, error) {
+	if passphrase := os.Getenv(config.EnvPrefix + "PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	fmt.Printf("New passphrase: ")
+	passphrase, err := cli.GetSecretInput(os.Stdin, os.Stdout)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Repeat passphrase: ")
+	repeated, err := cli.GetSecretInput(os.Stdin, os.Stdout)
+	if err != nil {
+		return "", err
+	}
+	if passphrase != repeated {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	loginCmd.Flags().StringVar(&loginBackend, "backend", "encrypted", "Where to store credentials: encrypted or file")
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/omushpapa/routerman/storage"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Manage bandwidth plans",
+}
+
+var planFlags struct {
+	name                           string
+	upMin, upMax, downMin, downMax int
+	devices                        int
+	propagate                      bool
+}
+
+var planListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List bandwidth plans",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			plans, err := env.Router.GetPlansContext(ctx)
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"NAME", "UP", "DOWN", "DEVICES"}}
+			for _, plan := range plans {
+				dataRows = append(dataRows, []string{
+					plan.Name,
+					fmt.Sprintf("%d/%d", plan.UpMin, plan.UpMax),
+					fmt.Sprintf("%d/%d", plan.DownMin, plan.DownMax),
+					strconv.Itoa(plan.Devices),
+				})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var planAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a bandwidth plan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan := storage.Plan{
+			Name:    args[0],
+			UpMin:   planFlags.upMin,
+			UpMax:   planFlags.upMax,
+			DownMin: planFlags.downMin,
+			DownMax: planFlags.downMax,
+			Devices: planFlags.devices,
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			_, err := env.Router.CreatePlanContext(ctx, plan)
+			return err
+		})
+	},
+}
+
+var planSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Change a bandwidth plan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			plan, err := env.Router.GetPlanContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if flags.Changed("name") {
+				plan.Name = planFlags.name
+			}
+			if flags.Changed("up-min") {
+				plan.UpMin = planFlags.upMin
+			}
+			if flags.Changed("up-max") {
+				plan.UpMax = planFlags.upMax
+			}
+			if flags.Changed("down-min") {
+				plan.DownMin = planFlags.downMin
+			}
+			if flags.Changed("down-max") {
+				plan.DownMax = planFlags.downMax
+			}
+			if flags.Changed("devices") {
+				plan.Devices = planFlags.devices
+			}
+			updated, err := env.Router.UpdatePlanContext(ctx, plan, planFlags.propagate)
+			if err != nil {
+				if updated > 0 {
+					fmt.Fprintf(env.Out, "%d slots updated before the error\n", updated)
+				}
+				return err
+			}
+			if planFlags.propagate {
+				fmt.Fprintf(env.Out, "%d slots updated\n", updated)
+			}
+			return nil
+		})
+	},
+}
+
+var planDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a bandwidth plan; slots using it keep their limits",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			plan, err := env.Router.GetPlanContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return env.Router.DeletePlanContext(ctx, plan.Id)
+		})
+	},
+}
+
+func addPlanLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&planFlags.upMin, "up-min", 50, "Minimum upload speed in kbps")
+	cmd.Flags().IntVar(&planFlags.upMax, "up-max", 1000, "Maximum upload speed in kbps")
+	cmd.Flags().IntVar(&planFlags.downMin, "down-min", 50, "Minimum download speed in kbps")
+	cmd.Flags().IntVar(&planFlags.downMax, "down-max", 1000, "Maximum download speed in kbps")
+	cmd.Flags().IntVar(&planFlags.devices, "devices", 1, "Default number of devices of a slot")
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.AddCommand(planListCmd)
+
+	planCmd.AddCommand(planAddCmd)
+	addPlanLimitFlags(planAddCmd)
+
+	planCmd.AddCommand(planSetCmd)
+	addPlanLimitFlags(planSetCmd)
+	planSetCmd.Flags().StringVar(&planFlags.name, "name", "", "New name of the plan")
+	planSetCmd.Flags().BoolVar(&planFlags.propagate, "propagate", false, "Apply the new limits to every slot using the plan")
+
+	planCmd.AddCommand(planDeleteCmd)
+}
@@ -1,17 +1,29 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"time"
 
 	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/config"
 	"github.com/omushpapa/routerman/core"
+	"github.com/omushpapa/routerman/credentials"
 	"github.com/omushpapa/routerman/storage"
 	"github.com/spf13/cobra"
 )
 
 var (
-	initDb bool
+	initDb    bool
+	dryRun    bool
+	noCache   bool
+	overrides config.Overrides
 )
 
 var rootCmd = &cobra.Command{
@@ -23,11 +35,11 @@ var dbCmd = &cobra.Command{
 	Use:   "db",
 	Short: "Database manager",
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg := storage.DbConfig{
-			Init: initDb,
-			URI:  "routerman.db",
+		settings, err := config.Resolve(overrides)
+		if err != nil {
+			exitWithError(err)
 		}
-		db, err := storage.ConnectDatabase(cfg)
+		db, err := openDatabase(settings)
 		if err != nil {
 			exitWithError(err)
 		}
@@ -41,11 +53,11 @@ var cliCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		in := os.Stdin
 		out := os.Stdout
-		cfg := storage.DbConfig{
-			Init: initDb,
-			URI:  "routerman.db",
+		settings, err := config.Resolve(overrides)
+		if err != nil {
+			exitWithError(err)
 		}
-		db, err := storage.ConnectDatabase(cfg)
+		db, err := openDatabase(settings)
 		if err != nil {
 			exitWithError(err)
 		}
@@ -55,22 +67,48 @@ var cliCmd = &cobra.Command{
 			cli.RootActionManageUsers,
 			cli.RootActionManageDevices,
 			cli.RootActionManageInternetAccess,
+			cli.RootActionManageGroups,
 			cli.ActionQuit,
 		}
 
-		router := core.NewRouterApi(
-			os.Getenv("USERNAME"),
-			os.Getenv("PASSWORD"),
-			os.Getenv("ADDRESS"),
-			db,
-		)
-
-		env := core.NewEnv(in, out, db, router)
+		env, err := newEnv(settings, db, in, out)
+		if err != nil {
+			exitWithError(err)
+		}
 
 		_, err = cli.RunMenuActions(env, actions)
+		env.Router.Close()
+		if err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List configured router profiles",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := config.Resolve(overrides)
 		if err != nil {
 			exitWithError(err)
 		}
+		path := settings.ConfigPath.Value
+		cfg, err := config.Load(path)
+		if err != nil {
+			exitWithError(err)
+		}
+		if len(cfg.Profiles) == 0 {
+			fmt.Printf("no profiles configured in '%s'\n", path)
+			return
+		}
+		for _, name := range cfg.ProfileNames() {
+			profile, _ := cfg.GetProfile(name)
+			marker := " "
+			if name == cfg.DefaultProfile {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\t%s\n", marker, name, profile.Address, profile.Database)
+		}
 	},
 }
 
@@ -82,10 +120,131 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Log router and database changes without applying them")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Always fetch router state instead of reusing recent responses")
+	rootCmd.PersistentFlags().StringVar(&overrides.Profile, "profile", "", "Router profile to use")
+	rootCmd.PersistentFlags().StringVar(&overrides.ConfigPath, "config", "", "Path to the config file")
+	rootCmd.PersistentFlags().StringVar(&overrides.Database, "db", "", "Path to the database")
+	rootCmd.PersistentFlags().StringVar(&overrides.Address, "address", "", "Router address")
+	rootCmd.PersistentFlags().StringVar(&overrides.Username, "username", "", "Router admin username")
+	rootCmd.PersistentFlags().StringVar(&overrides.Timeout, "timeout", "", "Timeout for each router call, e.g. 8s")
+	rootCmd.PersistentFlags().StringVar(&overrides.Retries, "retries", "", "Number of retries for failed router reads")
+	rootCmd.PersistentFlags().StringVar(&overrides.LogLevel, "log-level", "", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&overrides.LogFormat, "log-format", "", "Log format: text or json")
+
 	rootCmd.AddCommand(dbCmd)
 	dbCmd.Flags().BoolVar(&initDb, "init", false, "Initialise the database")
 
 	rootCmd.AddCommand(cliCmd)
+	rootCmd.AddCommand(profilesCmd)
+}
+
+func openDatabase(settings config.Settings) (*sql.DB, error) {
+	cfg := storage.DbConfig{
+		Init: initDb,
+		URI:  settings.Database.Value,
+	}
+	return storage.ConnectDatabase(cfg)
+}
+
+// newEnv connects to the router of the selected profile and applies the
+// global flags to it.
+func newEnv(settings config.Settings, db *sql.DB, in io.Reader, out io.Writer) (*core.Env, error) {
+	router, err := core.NewRouterApi(
+		settings.Profile.Value,
+		settings.Address.Value,
+		newCredentialsProvider(settings),
+		db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := resiliencePolicy(settings)
+	if err != nil {
+		return nil, err
+	}
+	router.SetResiliencePolicy(policy)
+	if noCache {
+		router.DisableCache()
+	}
+	if dryRun {
+		router.EnableDryRun(out)
+	}
+
+	logger, err := newLogger(settings)
+	if err != nil {
+		return nil, err
+	}
+	env := core.NewEnv(in, out, db, router)
+	env.SetLogger(logger)
+	return env, nil
+}
+
+// runWithEnv sets up the selected profile for a one-shot command and
+// cancels run on Ctrl-C.
+func runWithEnv(run func(ctx context.Context, env *core.Env) error) {
+	settings, err := config.Resolve(overrides)
+	if err != nil {
+		exitWithError(err)
+	}
+	db, err := openDatabase(settings)
+	if err != nil {
+		exitWithError(err)
+	}
+	defer db.Close()
+
+	env, err := newEnv(settings, db, os.Stdin, os.Stdout)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err = run(ctx, env)
+	stop()
+	env.Router.Close()
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+func resiliencePolicy(settings config.Settings) (core.ResiliencePolicy, error) {
+	policy := core.DefaultResiliencePolicy
+	timeout, err := time.ParseDuration(settings.Timeout.Value)
+	if err != nil {
+		return policy, fmt.Errorf("invalid timeout '%s'", settings.Timeout.Value)
+	}
+	retries, err := strconv.Atoi(settings.Retries.Value)
+	if err != nil || retries < 0 {
+		return policy, fmt.Errorf("invalid retries '%s'", settings.Retries.Value)
+	}
+	policy.Timeout = timeout
+	policy.Retries = retries
+	return policy, nil
+}
+
+// newLogger writes logs to stderr so they never mix with menu output.
+func newLogger(settings config.Settings) (*slog.Logger, error) {
+	return core.NewLogger(os.Stderr, settings.LogLevel.Value, settings.LogFormat.Value)
+}
+
+func getPassphrase() (string, error) {
+	if passphrase := os.Getenv(config.EnvPrefix + "PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	fmt.Fprintf(os.Stderr, "Credentials passphrase: ")
+	return cli.GetSecretInput(os.Stdin, os.Stderr)
+}
+
+// newCredentialsProvider prefers a password command, then credentials stored
+// by `routerman login`, and falls back to flags and environment variables.
+func newCredentialsProvider(settings config.Settings) credentials.Provider {
+	plainPath, encryptedPath := settings.CredentialPaths()
+	return credentials.Chain{
+		credentials.Command{Command: settings.PasswordCommand.Value, Username: settings.Username.Value},
+		credentials.EncryptedFile{Path: encryptedPath, Passphrase: getPassphrase},
+		credentials.File{Path: plainPath},
+		credentials.Static{Username: settings.Username.Value, Password: settings.Password.Value},
+	}
 }
 
 func exitWithError(err error) {
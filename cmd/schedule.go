@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/omushpapa/routerman/storage"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage time-of-day bandwidth limits of slots",
+}
+
+var scheduleFlags struct {
+	slotId                         int
+	days, from, to                 string
+	upMin, upMax, downMin, downMax int
+	interval                       time.Duration
+	once                           bool
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List schedules",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			var (
+				schedules []storage.SlotSchedule
+				err       error
+			)
+			if scheduleFlags.slotId != 0 {
+				schedules, err = env.Router.GetSlotSchedulesContext(ctx, scheduleFlags.slotId)
+			} else {
+				schedules, err = env.Router.GetSchedulesContext(ctx)
+			}
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"ID", "SLOT", "DAYS", "TIME", "UP", "DOWN"}}
+			for _, schedule := range schedules {
+				days := schedule.Days
+				if days == "" {
+					days = "every day"
+				}
+				dataRows = append(dataRows, []string{
+					strconv.Itoa(schedule.Id),
+					strconv.Itoa(schedule.SlotId),
+					days,
+					fmt.Sprintf("%s - %s", schedule.Start, schedule.End),
+					fmt.Sprintf("%d/%d", schedule.UpMin, schedule.UpMax),
+					fmt.Sprintf("%d/%d", schedule.DownMin, schedule.DownMax),
+				})
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var scheduleAddCmd = &cobra.Command{
+	Use:   "add <slot-id>",
+	Short: "Add a schedule to a slot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		schedule := storage.SlotSchedule{
+			SlotId:  parseId(args[0]),
+			Days:    scheduleFlags.days,
+			Start:   scheduleFlags.from,
+			End:     scheduleFlags.to,
+			UpMin:   scheduleFlags.upMin,
+			UpMax:   scheduleFlags.upMax,
+			DownMin: scheduleFlags.downMin,
+			DownMax: scheduleFlags.downMax,
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			schedule, err := env.Router.AddSlotScheduleContext(ctx, schedule)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.Out, "schedule %d added\n", schedule.Id)
+			return nil
+		})
+	},
+}
+
+var scheduleDeleteCmd = &cobra.Command{
+	Use:   "delete <schedule-id>",
+	Short: "Delete a schedule",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduleId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			return env.Router.DeleteSlotScheduleContext(ctx, scheduleId)
+		})
+	},
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Apply schedules to the router until interrupted",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !scheduleFlags.once && scheduleFlags.interval <= 0 {
+			exitWithError(&core.SoftError{Message: fmt.Sprintf("Interval must be positive, got %s", scheduleFlags.interval)})
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			env.Router.DisableCache()
+			if scheduleFlags.once {
+				changed, err := env.Router.ApplySchedulesContext(ctx, time.Now())
+				if err != nil {
+					if changed > 0 {
+						fmt.Fprintf(env.Out, "%d slots updated before the error\n", changed)
+					}
+					return err
+				}
+				fmt.Fprintf(env.Out, "%d slots updated\n", changed)
+				return nil
+			}
+			env.Logger.Info("scheduler started", "interval", scheduleFlags.interval)
+			return env.Router.RunSchedulerContext(ctx, scheduleFlags.interval)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+
+	scheduleCmd.AddCommand(scheduleListCmd)
+	scheduleListCmd.Flags().IntVar(&scheduleFlags.slotId, "slot", 0, "Only list schedules of this slot")
+
+	scheduleCmd.AddCommand(scheduleAddCmd)
+	scheduleAddCmd.Flags().StringVar(&scheduleFlags.days, "days", "", "Comma separated days, e.g. mon,tue; every day when empty")
+	scheduleAddCmd.Flags().StringVar(&scheduleFlags.from, "from", "", "Start time HH:MM")
+	scheduleAddCmd.Flags().StringVar(&scheduleFlags.to, "to", "", "End time HH:MM, before the start time to run past midnight")
+	scheduleAddCmd.Flags().IntVar(&scheduleFlags.upMin, "up-min", 50, "Minimum upload speed in kbps")
+	scheduleAddCmd.Flags().IntVar(&scheduleFlags.upMax, "up-max", 1000, "Maximum upload speed in kbps")
+	scheduleAddCmd.Flags().IntVar(&scheduleFlags.downMin, "down-min", 50, "Minimum download speed in kbps")
+	scheduleAddCmd.Flags().IntVar(&scheduleFlags.downMax, "down-max", 1000, "Maximum download speed in kbps")
+	scheduleAddCmd.MarkFlagRequired("from")
+	scheduleAddCmd.MarkFlagRequired("to")
+
+	scheduleCmd.AddCommand(scheduleDeleteCmd)
+
+	scheduleCmd.AddCommand(scheduleRunCmd)
+	scheduleRunCmd.Flags().DurationVar(&scheduleFlags.interval, "interval", time.Minute, "How often schedules are applied")
+	scheduleRunCmd.Flags().BoolVar(&scheduleFlags.once, "once", false, "Apply schedules once and exit")
+}
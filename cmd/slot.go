@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/spf13/cobra"
+)
+
+var slotCmd = &cobra.Command{
+	Use:   "slot",
+	Short: "Manage bandwidth slots",
+}
+
+var slotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List bandwidth slots",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			slots, err := env.Router.GetSlotsContext(ctx)
+			if err != nil {
+				return err
+			}
+			dataRows := [][]string{{"ID", "USER", "RANGE", "UP", "DOWN", "ENABLED", "PLAN"}}
+			for _, slot := range slots {
+				dataRows = append(dataRows, cli.SlotRow(env, slot))
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var slotResizeCmd = &cobra.Command{
+	Use:   "resize <slot-id> <devices>",
+	Short: "Change the number of addresses in a slot",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		slotId := parseId(args[0])
+		devices, err := strconv.Atoi(args[1])
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid number of devices '%s'", args[1]))
+		}
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			return env.Router.ResizeSlotContext(ctx, slotId, devices)
+		})
+	},
+}
+
+var slotMoveCmd = &cobra.Command{
+	Use:   "move <slot-id> <start-ip>",
+	Short: "Move a slot to a new start address",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		slotId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			return env.Router.MoveSlotContext(ctx, slotId, args[1])
+		})
+	},
+}
+
+var slotAssignFlags struct {
+	plan          string
+	devices       int
+	useDhcpBounds bool
+}
+
+var slotAssignCmd = &cobra.Command{
+	Use:   "assign <user-id>",
+	Short: "Give a user a slot with the limits of a plan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		userId := parseId(args[0])
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			plan, err := env.Router.GetPlanContext(ctx, slotAssignFlags.plan)
+			if err != nil {
+				return err
+			}
+			devices := slotAssignFlags.devices
+			if devices == 0 {
+				devices = plan.Devices
+			}
+			slot, err := env.Router.AllocateBandwidthSlotContext(ctx, devices, core.BestFit, slotAssignFlags.useDhcpBounds)
+			if err != nil {
+				return err
+			}
+			if err = env.Router.AssignPlanSlotContext(ctx, userId, slot, "", devices, plan.Name); err != nil {
+				return err
+			}
+			fmt.Fprintf(env.Out, "assigned %s - %s\n", slot.MinAddress, slot.MaxAddress)
+			return nil
+		})
+	},
+}
+
+var slotLimitFlags struct {
+	upMin, upMax, downMin, downMax int
+	enabled                        bool
+	plan                           string
+}
+
+var slotSetCmd = &cobra.Command{
+	Use:   "set <slot-id>",
+	Short: "Change the bandwidth limits, plan or enabled flag of a slot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		slotId := parseId(args[0])
+		flags := cmd.Flags()
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			if flags.Changed("plan") {
+				if err := env.Router.SetSlotPlanContext(ctx, slotId, slotLimitFlags.plan); err != nil {
+					return err
+				}
+			}
+			slot, err := env.Router.GetSlotContext(ctx, slotId)
+			if err != nil {
+				return err
+			}
+			limits := slot.Limits()
+			enabled := slot.Entry.Enabled
+			if flags.Changed("up-min") {
+				limits.UpMin = slotLimitFlags.upMin
+			}
+			if flags.Changed("up-max") {
+				limits.UpMax = slotLimitFlags.upMax
+			}
+			if flags.Changed("down-min") {
+				limits.DownMin = slotLimitFlags.downMin
+			}
+			if flags.Changed("down-max") {
+				limits.DownMax = slotLimitFlags.downMax
+			}
+			if flags.Changed("enabled") {
+				enabled = slotLimitFlags.enabled
+			}
+			return env.Router.UpdateSlotContext(ctx, slotId, limits, enabled)
+		})
+	},
+}
+
+func slotEnableCmd(enabled bool) *cobra.Command {
+	use, short := "enable <slot-id>", "Enable a slot's bandwidth entry"
+	if !enabled {
+		use, short = "disable <slot-id>", "Remove a slot's bandwidth entry but keep its address range"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			slotId := parseId(args[0])
+			runWithEnv(func(ctx context.Context, env *core.Env) error {
+				return env.Router.SetSlotEnabledContext(ctx, slotId, enabled)
+			})
+		},
+	}
+}
+
+func parseId(value string) int {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid id '%s'", value))
+	}
+	return id
+}
+
+func init() {
+	rootCmd.AddCommand(slotCmd)
+	slotCmd.AddCommand(slotListCmd)
+	slotCmd.AddCommand(slotResizeCmd)
+	slotCmd.AddCommand(slotMoveCmd)
+	slotCmd.AddCommand(slotEnableCmd(true))
+	slotCmd.AddCommand(slotEnableCmd(false))
+
+	slotCmd.AddCommand(slotAssignCmd)
+	slotAssignCmd.Flags().StringVar(&slotAssignFlags.plan, "plan", "", "Plan to take the limits from")
+	slotAssignCmd.Flags().IntVar(&slotAssignFlags.devices, "devices", 0, "Number of devices, defaults to the plan's")
+	slotAssignCmd.Flags().BoolVar(&slotAssignFlags.useDhcpBounds, "dhcp-bounds", false, "Allocate within the DHCP address bounds")
+	slotAssignCmd.MarkFlagRequired("plan")
+
+	slotCmd.AddCommand(slotSetCmd)
+	slotSetCmd.Flags().IntVar(&slotLimitFlags.upMin, "up-min", 0, "Minimum upload speed in kbps")
+	slotSetCmd.Flags().IntVar(&slotLimitFlags.upMax, "up-max", 0, "Maximum upload speed in kbps")
+	slotSetCmd.Flags().IntVar(&slotLimitFlags.downMin, "down-min", 0, "Minimum download speed in kbps")
+	slotSetCmd.Flags().IntVar(&slotLimitFlags.downMax, "down-max", 0, "Maximum download speed in kbps")
+	slotSetCmd.Flags().BoolVar(&slotLimitFlags.enabled, "enabled", true, "Whether the bandwidth entry is active")
+	slotSetCmd.Flags().StringVar(&slotLimitFlags.plan, "plan", "", "Apply and link the limits of a plan before other changes")
+}
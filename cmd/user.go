@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/omushpapa/routerman/cli"
+	"github.com/omushpapa/routerman/core"
+	"github.com/spf13/cobra"
+)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage users",
+}
+
+func userEnableCmd(enabled bool) *cobra.Command {
+	use, short, state := "enable <name>", "Enable all bandwidth slots of a user", "enabled"
+	if !enabled {
+		use, short, state = "disable <name>", "Disable all bandwidth slots of a user, keeping their address ranges", "disabled"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runWithEnv(func(ctx context.Context, env *core.Env) error {
+				user, err := env.Store.UserStore.ReadByNameContext(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				changed, err := env.Router.SetUserSlotsEnabledContext(ctx, user.Id, enabled)
+				if err != nil {
+					if changed > 0 {
+						fmt.Fprintf(env.Out, "%d slots %s before the error\n", changed, state)
+					}
+					return err
+				}
+				fmt.Fprintf(env.Out, "%d slots %s\n", changed, state)
+				return nil
+			})
+		},
+	}
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users",
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			dataRows := [][]string{{"ID", "NAME", "CONTACT", "ADDED"}}
+			for pageNumber := 1; ; pageNumber++ {
+				users, err := env.Store.UserStore.ReadManyContext(ctx, 100, pageNumber)
+				if err != nil {
+					return err
+				}
+				for _, user := range users {
+					dataRows = append(dataRows, []string{
+						strconv.Itoa(user.Id), user.Name, user.Contact, user.CreatedAt.Local().Format("2006-01-02"),
+					})
+				}
+				if len(users) < 100 {
+					break
+				}
+			}
+			return cli.PrintTable(env.Out, dataRows, false, 0)
+		})
+	},
+}
+
+var userSetFlags struct {
+	contact, notes string
+}
+
+var userSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Change the contact or notes of a user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			user, err := env.Store.UserStore.ReadByNameContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			contact, notes := user.Contact, user.Notes
+			if cmd.Flags().Changed("contact") {
+				contact = userSetFlags.contact
+			}
+			if cmd.Flags().Changed("notes") {
+				notes = userSetFlags.notes
+			}
+			return env.Router.SetUserInfoContext(ctx, user.Id, contact, notes)
+		})
+	},
+}
+
+var userShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show the devices and bandwidth slots of a user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			user, err := env.Store.UserStore.ReadByNameContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			details, err := env.Router.GetUserDetailsContext(ctx, user.Id)
+			if err != nil {
+				return err
+			}
+			return cli.PrintUserDetails(env.Out, details)
+		})
+	},
+}
+
+var userRenameCmd = &cobra.Command{
+	Use:   "rename <name> <new-name>",
+	Short: "Rename a user",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWithEnv(func(ctx context.Context, env *core.Env) error {
+			user, err := env.Store.UserStore.ReadByNameContext(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return env.Router.RenameUserContext(ctx, user.Id, args[1])
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userEnableCmd(true))
+	userCmd.AddCommand(userEnableCmd(false))
+	userCmd.AddCommand(userListCmd)
+	userCmd.AddCommand(userShowCmd)
+	userCmd.AddCommand(userSetCmd)
+	userSetCmd.Flags().StringVar(&userSetFlags.contact, "contact", "", "Phone number, email or other contact")
+	userSetCmd.Flags().StringVar(&userSetFlags.notes, "notes", "", "Free-form notes")
+	userCmd.AddCommand(userRenameCmd)
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+const DefaultDatabase = "routerman.db"
+
+type Profile struct {
+	Name            string `json:"-"`
+	Address         string `json:"address"`
+	Username        string `json:"username"`
+	PasswordEnv     string `json:"password_env"`
+	PasswordCommand string `json:"password_command"`
+	Database        string `json:"database"`
+	Timeout         string `json:"timeout"`
+	Retries         *int   `json:"retries"`
+}
+
+func (profile Profile) Password() string {
+	if profile.PasswordEnv == "" {
+		return ""
+	}
+	return os.Getenv(profile.PasswordEnv)
+}
+
+type Config struct {
+	DefaultProfile string             `json:"default_profile"`
+	LogLevel       string             `json:"log_level"`
+	LogFormat      string             `json:"log_format"`
+	Profiles       map[string]Profile `json:"profiles"`
+}
+
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "routerman", "config.json"), nil
+}
+
+func Load(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file '%s': %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg Config) ProfileNames() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile resolves a profile by name, falling back to the configured
+// default. The implicit default profile is empty unless configured.
+func (cfg Config) GetProfile(name string) (Profile, error) {
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		name = storage.DefaultRouter
+	}
+
+	profile, exists := cfg.Profiles[name]
+	if !exists && name != storage.DefaultRouter {
+		return profile, fmt.Errorf("profile '%s' not found", name)
+	}
+	profile.Name = name
+	return profile, nil
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+const EnvPrefix = "ROUTERMAN_"
+
+const (
+	DefaultTimeout   = "8s"
+	DefaultRetries   = 3
+	DefaultLogLevel  = "warn"
+	DefaultLogFormat = "text"
+)
+
+const (
+	SourceDefault = "default"
+	SourceFile    = "config file"
+	SourceEnv     = "environment"
+	SourceFlag    = "flag"
+)
+
+// Overrides holds values supplied on the command line. Empty values are
+// treated as unset.
+type Overrides struct {
+	ConfigPath string
+	Profile    string
+	Address    string
+	Username   string
+	Database   string
+	Timeout    string
+	Retries    string
+	LogLevel   string
+	LogFormat  string
+}
+
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+	Secret bool
+}
+
+func (setting Setting) Display() string {
+	if setting.Secret && setting.Value != "" {
+		return "********"
+	}
+	return setting.Value
+}
+
+// Settings are resolved in the order flag, ROUTERMAN_ environment variable,
+// profile in the config file and finally built-in defaults.
+type Settings struct {
+	ConfigPath      Setting
+	Profile         Setting
+	Address         Setting
+	Username        Setting
+	Password        Setting
+	PasswordCommand Setting
+	Database        Setting
+	Timeout         Setting
+	Retries         Setting
+	LogLevel        Setting
+	LogFormat       Setting
+}
+
+func (settings Settings) All() []Setting {
+	return []Setting{
+		settings.ConfigPath,
+		settings.Profile,
+		settings.Address,
+		settings.Username,
+		settings.Password,
+		settings.PasswordCommand,
+		settings.Database,
+		settings.Timeout,
+		settings.Retries,
+		settings.LogLevel,
+		settings.LogFormat,
+	}
+}
+
+// CredentialPaths returns where `routerman login` stores the plain and
+// encrypted credentials of the selected profile.
+func (settings Settings) CredentialPaths() (string, string) {
+	dir := filepath.Join(filepath.Dir(settings.ConfigPath.Value), "credentials")
+	name := settings.Profile.Value
+	return filepath.Join(dir, name+".json"), filepath.Join(dir, name+".enc")
+}
+
+func resolve(name, flagValue, envKey, fileValue, defaultValue string) Setting {
+	setting := Setting{Name: name}
+	if envKey != "" {
+		envKey = EnvPrefix + envKey
+	}
+	switch {
+	case flagValue != "":
+		setting.Value, setting.Source = flagValue, SourceFlag
+	case envKey != "" && os.Getenv(envKey) != "":
+		setting.Value, setting.Source = os.Getenv(envKey), SourceEnv
+	case fileValue != "":
+		setting.Value, setting.Source = fileValue, SourceFile
+	default:
+		setting.Value, setting.Source = defaultValue, SourceDefault
+	}
+	return setting
+}
+
+func Resolve(overrides Overrides) (Settings, error) {
+	var settings Settings
+
+	defaultPath, err := DefaultPath()
+	if err != nil {
+		return settings, err
+	}
+	settings.ConfigPath = resolve("config", overrides.ConfigPath, "CONFIG", "", defaultPath)
+
+	cfg, err := Load(settings.ConfigPath.Value)
+	if err != nil {
+		return settings, err
+	}
+	settings.LogLevel = resolve("log_level", overrides.LogLevel, "LOG_LEVEL", cfg.LogLevel, DefaultLogLevel)
+	settings.LogFormat = resolve("log_format", overrides.LogFormat, "LOG_FORMAT", cfg.LogFormat, DefaultLogFormat)
+
+	settings.Profile = resolve("profile", overrides.Profile, "PROFILE", cfg.DefaultProfile, storage.DefaultRouter)
+	profile, err := cfg.GetProfile(settings.Profile.Value)
+	if err != nil {
+		return settings, err
+	}
+
+	settings.Address = resolve("address", overrides.Address, "ADDRESS", profile.Address, "")
+	settings.Username = resolve("username", overrides.Username, "USERNAME", profile.Username, "")
+	settings.Password = resolve("password", "", "PASSWORD", profile.Password(), "")
+	settings.Password.Secret = true
+	settings.PasswordCommand = resolve("password_command", "", "PASSWORD_COMMAND", profile.PasswordCommand, "")
+	settings.Database = resolve("db", overrides.Database, "DB", profile.Database, DefaultDatabase)
+	settings.Timeout = resolve("timeout", overrides.Timeout, "TIMEOUT", profile.Timeout, DefaultTimeout)
+
+	var retries string
+	if profile.Retries != nil {
+		retries = strconv.Itoa(*profile.Retries)
+	}
+	settings.Retries = resolve("retries", overrides.Retries, "RETRIES", retries, strconv.Itoa(DefaultRetries))
+	return settings, nil
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+var ErrNoFreeAddresses = errors.New("no free addresses")
+
+type AllocationStrategy int
+
+const (
+	// FirstFit takes the lowest free range that is large enough.
+	FirstFit AllocationStrategy = iota
+	// BestFit takes the smallest free range that is large enough, keeping
+	// larger ranges available for larger requests.
+	BestFit
+)
+
+// AddrRange is an inclusive range of IPv4 addresses.
+type AddrRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+func NewAddrRange(start, end string) (AddrRange, error) {
+	var r AddrRange
+	startAddr, err := parseIPv4(start)
+	if err != nil {
+		return r, err
+	}
+	endAddr, err := parseIPv4(end)
+	if err != nil {
+		return r, err
+	}
+	if endAddr.Less(startAddr) {
+		return r, fmt.Errorf("invalid range '%s - %s'", start, end)
+	}
+	return AddrRange{Start: startAddr, End: endAddr}, nil
+}
+
+func SingleAddr(addr netip.Addr) AddrRange {
+	return AddrRange{Start: addr, End: addr}
+}
+
+func (r AddrRange) Size() int {
+	return int(addrToUint32(r.End)-addrToUint32(r.Start)) + 1
+}
+
+func (r AddrRange) Contains(addr netip.Addr) bool {
+	return !addr.Less(r.Start) && !r.End.Less(addr)
+}
+
+func (r AddrRange) String() string {
+	return fmt.Sprintf("%s - %s", r.Start, r.End)
+}
+
+// AddrSet holds IPv4 addresses as sorted, non-overlapping ranges, so large
+// subnets cost one element per range instead of one per address.
+type AddrSet struct {
+	ranges []AddrRange
+}
+
+func (s *AddrSet) Add(r AddrRange) {
+	merged := make([]AddrRange, 0, len(s.ranges)+1)
+	for _, existing := range s.ranges {
+		switch {
+		case separateBefore(existing, r):
+			merged = append(merged, existing)
+		case separateBefore(r, existing):
+			merged = append(merged, r)
+			r = existing
+		default:
+			if existing.Start.Less(r.Start) {
+				r.Start = existing.Start
+			}
+			if r.End.Less(existing.End) {
+				r.End = existing.End
+			}
+		}
+	}
+	s.ranges = append(merged, r)
+}
+
+func (s *AddrSet) Remove(r AddrRange) {
+	remaining := make([]AddrRange, 0, len(s.ranges)+1)
+	for _, existing := range s.ranges {
+		if existing.End.Less(r.Start) || r.End.Less(existing.Start) {
+			remaining = append(remaining, existing)
+			continue
+		}
+		if existing.Start.Less(r.Start) {
+			remaining = append(remaining, AddrRange{Start: existing.Start, End: r.Start.Prev()})
+		}
+		if r.End.Less(existing.End) {
+			remaining = append(remaining, AddrRange{Start: r.End.Next(), End: existing.End})
+		}
+	}
+	s.ranges = remaining
+}
+
+func (s AddrSet) Contains(addr netip.Addr) bool {
+	for _, r := range s.ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s AddrSet) Ranges() []AddrRange {
+	return append([]AddrRange(nil), s.ranges...)
+}
+
+func (s AddrSet) Size() int {
+	size := 0
+	for _, r := range s.ranges {
+		size += r.Size()
+	}
+	return size
+}
+
+// Allocate returns the first n addresses of a free range chosen by strategy.
+// The set itself is left unchanged.
+func (s AddrSet) Allocate(n int, strategy AllocationStrategy) (AddrRange, error) {
+	var (
+		chosen AddrRange
+		found  bool
+	)
+	if n < 1 {
+		return chosen, fmt.Errorf("invalid number of addresses '%d'", n)
+	}
+	for _, r := range s.ranges {
+		if r.Size() < n {
+			continue
+		}
+		if !found || (strategy == BestFit && r.Size() < chosen.Size()) {
+			chosen, found = r, true
+		}
+		if strategy == FirstFit {
+			break
+		}
+	}
+	if !found {
+		return chosen, fmt.Errorf("%w: no block of %d addresses", ErrNoFreeAddresses, n)
+	}
+	chosen.End = uint32ToAddr(addrToUint32(chosen.Start) + uint32(n-1))
+	return chosen, nil
+}
+
+// separateBefore reports whether a ends more than one address before b
+// starts, i.e. the two can not be merged.
+func separateBefore(a, b AddrRange) bool {
+	return a.End.Less(b.Start) && a.End.Next() != b.Start
+}
+
+func parseIPv4(value string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil || !addr.Is4() {
+		return netip.Addr{}, fmt.Errorf("invalid IPv4 address '%s'", value)
+	}
+	return addr, nil
+}
+
+func addrToUint32(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func uint32ToAddr(value uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], value)
+	return netip.AddrFrom4(b)
+}
+
+// hostRange returns the addresses of a prefix that can be given to hosts,
+// leaving out the network and broadcast addresses.
+func hostRange(prefix netip.Prefix) (AddrRange, error) {
+	prefix = prefix.Masked()
+	bits := prefix.Bits()
+	if !prefix.Addr().Is4() || bits < 1 || bits > 30 {
+		return AddrRange{}, fmt.Errorf("invalid subnet prefix '%d'", bits)
+	}
+	network := addrToUint32(prefix.Addr())
+	broadcast := network | (^uint32(0) >> bits)
+	return AddrRange{Start: uint32ToAddr(network + 1), End: uint32ToAddr(broadcast - 1)}, nil
+}
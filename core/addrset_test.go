@@ -0,0 +1,260 @@
+package core
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func mustRange(t *testing.T, start, end string) AddrRange {
+	t.Helper()
+	r, err := NewAddrRange(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func mustRanges(t *testing.T, bounds ...string) []AddrRange {
+	t.Helper()
+	ranges := make([]AddrRange, 0, len(bounds)/2)
+	for i := 0; i+1 < len(bounds); i += 2 {
+		ranges = append(ranges, mustRange(t, bounds[i], bounds[i+1]))
+	}
+	return ranges
+}
+
+func equalRanges(a, b []AddrRange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddrSetAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		added []string
+		want  []string
+	}{
+		{
+			name:  "empty",
+			added: nil,
+			want:  nil,
+		},
+		{
+			name:  "separate ranges are sorted",
+			added: []string{"10.0.0.20", "10.0.0.29", "10.0.0.1", "10.0.0.9"},
+			want:  []string{"10.0.0.1", "10.0.0.9", "10.0.0.20", "10.0.0.29"},
+		},
+		{
+			name:  "adjacent ranges merge",
+			added: []string{"10.0.0.1", "10.0.0.9", "10.0.0.10", "10.0.0.19"},
+			want:  []string{"10.0.0.1", "10.0.0.19"},
+		},
+		{
+			name:  "overlapping ranges merge",
+			added: []string{"10.0.0.1", "10.0.0.12", "10.0.0.8", "10.0.0.19"},
+			want:  []string{"10.0.0.1", "10.0.0.19"},
+		},
+		{
+			name:  "range bridging two ranges merges all three",
+			added: []string{"10.0.0.1", "10.0.0.5", "10.0.0.20", "10.0.0.25", "10.0.0.6", "10.0.0.19"},
+			want:  []string{"10.0.0.1", "10.0.0.25"},
+		},
+		{
+			name:  "contained range is absorbed",
+			added: []string{"10.0.0.1", "10.0.0.50", "10.0.0.10", "10.0.0.20"},
+			want:  []string{"10.0.0.1", "10.0.0.50"},
+		},
+		{
+			name:  "ranges across an octet boundary merge",
+			added: []string{"10.0.0.200", "10.0.0.255", "10.0.1.0", "10.0.1.10"},
+			want:  []string{"10.0.0.200", "10.0.1.10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var set AddrSet
+			for _, r := range mustRanges(t, tt.added...) {
+				set.Add(r)
+			}
+			if got, want := set.Ranges(), mustRanges(t, tt.want...); !equalRanges(got, want) {
+				t.Errorf("Ranges() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddrSetRemove(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  []string
+		removed  []string
+		want     []string
+		wantSize int
+	}{
+		{
+			name:     "disjoint removal keeps the set",
+			initial:  []string{"10.0.0.1", "10.0.0.10"},
+			removed:  []string{"10.0.0.20", "10.0.0.30"},
+			want:     []string{"10.0.0.1", "10.0.0.10"},
+			wantSize: 10,
+		},
+		{
+			name:     "single address in the middle splits the range",
+			initial:  []string{"10.0.0.1", "10.0.0.10"},
+			removed:  []string{"10.0.0.5", "10.0.0.5"},
+			want:     []string{"10.0.0.1", "10.0.0.4", "10.0.0.6", "10.0.0.10"},
+			wantSize: 9,
+		},
+		{
+			name:     "first and last addresses",
+			initial:  []string{"10.0.0.1", "10.0.0.10"},
+			removed:  []string{"10.0.0.1", "10.0.0.1", "10.0.0.10", "10.0.0.10"},
+			want:     []string{"10.0.0.2", "10.0.0.9"},
+			wantSize: 8,
+		},
+		{
+			name:     "overlapping the start",
+			initial:  []string{"10.0.0.10", "10.0.0.20"},
+			removed:  []string{"10.0.0.5", "10.0.0.12"},
+			want:     []string{"10.0.0.13", "10.0.0.20"},
+			wantSize: 8,
+		},
+		{
+			name:     "spanning several ranges",
+			initial:  []string{"10.0.0.1", "10.0.0.5", "10.0.0.10", "10.0.0.15", "10.0.0.20", "10.0.0.25"},
+			removed:  []string{"10.0.0.3", "10.0.0.22"},
+			want:     []string{"10.0.0.1", "10.0.0.2", "10.0.0.23", "10.0.0.25"},
+			wantSize: 5,
+		},
+		{
+			name:     "whole set",
+			initial:  []string{"10.0.0.1", "10.0.0.10"},
+			removed:  []string{"10.0.0.0", "10.0.0.255"},
+			want:     nil,
+			wantSize: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var set AddrSet
+			for _, r := range mustRanges(t, tt.initial...) {
+				set.Add(r)
+			}
+			for _, r := range mustRanges(t, tt.removed...) {
+				set.Remove(r)
+			}
+			if got, want := set.Ranges(), mustRanges(t, tt.want...); !equalRanges(got, want) {
+				t.Errorf("Ranges() = %v, want %v", got, want)
+			}
+			if got := set.Size(); got != tt.wantSize {
+				t.Errorf("Size() = %d, want %d", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestAddrSetAllocate(t *testing.T) {
+	// Free ranges of 4, 10 and 6 addresses.
+	free := []string{
+		"10.0.0.10", "10.0.0.13",
+		"10.0.0.20", "10.0.0.29",
+		"10.0.0.40", "10.0.0.45",
+	}
+	tests := []struct {
+		name     string
+		n        int
+		strategy AllocationStrategy
+		want     []string
+		wantErr  error
+	}{
+		{name: "first fit takes the lowest range", n: 3, strategy: FirstFit, want: []string{"10.0.0.10", "10.0.0.12"}},
+		{name: "best fit takes the lowest range when it is the smallest", n: 3, strategy: BestFit, want: []string{"10.0.0.10", "10.0.0.12"}},
+		{name: "first fit skips ranges that are too small", n: 5, strategy: FirstFit, want: []string{"10.0.0.20", "10.0.0.24"}},
+		{name: "best fit takes the smallest range that fits", n: 5, strategy: BestFit, want: []string{"10.0.0.40", "10.0.0.44"}},
+		{name: "exact fit", n: 6, strategy: BestFit, want: []string{"10.0.0.40", "10.0.0.45"}},
+		{name: "largest range", n: 10, strategy: BestFit, want: []string{"10.0.0.20", "10.0.0.29"}},
+		{name: "single address", n: 1, strategy: FirstFit, want: []string{"10.0.0.10", "10.0.0.10"}},
+		{name: "too large", n: 11, strategy: FirstFit, wantErr: ErrNoFreeAddresses},
+		{name: "too large for best fit", n: 11, strategy: BestFit, wantErr: ErrNoFreeAddresses},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var set AddrSet
+			for _, r := range mustRanges(t, free...) {
+				set.Add(r)
+			}
+			got, err := set.Allocate(tt.n, tt.strategy)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Allocate(%d) error = %v, want %v", tt.n, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Allocate(%d) error = %v", tt.n, err)
+			}
+			if want := mustRange(t, tt.want[0], tt.want[1]); got != want {
+				t.Errorf("Allocate(%d) = %v, want %v", tt.n, got, want)
+			}
+			if got.Size() != tt.n {
+				t.Errorf("Allocate(%d) returned %d addresses", tt.n, got.Size())
+			}
+			if set.Size() != 20 {
+				t.Errorf("Allocate changed the set, size %d", set.Size())
+			}
+		})
+	}
+
+	t.Run("invalid count", func(t *testing.T) {
+		var set AddrSet
+		set.Add(mustRange(t, "10.0.0.1", "10.0.0.10"))
+		for _, n := range []int{0, -1} {
+			if _, err := set.Allocate(n, FirstFit); err == nil || errors.Is(err, ErrNoFreeAddresses) {
+				t.Errorf("Allocate(%d) error = %v, want an invalid count error", n, err)
+			}
+		}
+	})
+}
+
+func TestHostRange(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    []string
+		wantErr bool
+	}{
+		{prefix: "192.168.0.1/24", want: []string{"192.168.0.1", "192.168.0.254"}},
+		{prefix: "192.168.0.77/24", want: []string{"192.168.0.1", "192.168.0.254"}},
+		{prefix: "172.16.5.1/16", want: []string{"172.16.0.1", "172.16.255.254"}},
+		{prefix: "10.0.0.130/25", want: []string{"10.0.0.129", "10.0.0.254"}},
+		{prefix: "10.0.0.5/30", want: []string{"10.0.0.5", "10.0.0.6"}},
+		{prefix: "10.0.0.5/31", wantErr: true},
+		{prefix: "10.0.0.5/32", wantErr: true},
+		{prefix: "10.0.0.5/0", wantErr: true},
+		{prefix: "fd00::1/64", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := hostRange(netip.MustParsePrefix(tt.prefix))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("hostRange(%s) = %v, want an error", tt.prefix, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("hostRange(%s) error = %v", tt.prefix, err)
+			}
+			if want := mustRange(t, tt.want[0], tt.want[1]); got != want {
+				t.Errorf("hostRange(%s) = %v, want %v", tt.prefix, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/omushpapa/tplinkapi"
+)
+
+const (
+	resourceRouterInfo   = "router_info"
+	resourceLanConfig    = "lan_config"
+	resourceDhcp         = "dhcp"
+	resourceStatistics   = "statistics"
+	resourceReservations = "reservations"
+	resourceBindings     = "bindings"
+	resourceBandwidth    = "bandwidth"
+	resourceHosts        = "access_control_hosts"
+	resourceRules        = "access_control_rules"
+)
+
+var DefaultCacheTTLs = map[string]time.Duration{
+	resourceRouterInfo:   10 * time.Minute,
+	resourceLanConfig:    5 * time.Minute,
+	resourceDhcp:         5 * time.Minute,
+	resourceStatistics:   10 * time.Second,
+	resourceReservations: time.Minute,
+	resourceBindings:     time.Minute,
+	resourceBandwidth:    time.Minute,
+	resourceHosts:        time.Minute,
+	resourceRules:        time.Minute,
+}
+
+type cacheEntry struct {
+	resource string
+	value    interface{}
+	expires  time.Time
+}
+
+// cachedService answers repeated reads from memory until their TTL expires.
+// Writes drop every cached read of the resources they touch.
+type cachedService struct {
+	RouterService
+	*cacheState
+}
+
+// cacheState is shared by the copies of a cachedService bound to a context.
+type cacheState struct {
+	mu      sync.Mutex
+	ttls    map[string]time.Duration
+	entries map[string]cacheEntry
+	enabled bool
+}
+
+func newCachedService(service RouterService, ttls map[string]time.Duration) *cachedService {
+	return &cachedService{
+		RouterService: service,
+		cacheState: &cacheState{
+			ttls:    ttls,
+			entries: make(map[string]cacheEntry),
+			enabled: true,
+		},
+	}
+}
+
+func (c *cachedService) withContext(ctx context.Context) RouterService {
+	return &cachedService{RouterService: bindContext(c.RouterService, ctx), cacheState: c.cacheState}
+}
+
+func cached[T any](c *cachedService, resource, key string, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	enabled := c.enabled
+	entry, exists := c.entries[key]
+	c.mu.Unlock()
+
+	if enabled && exists && time.Now().Before(entry.expires) {
+		return entry.value.(T), nil
+	}
+
+	value, err := fetch()
+	if err != nil || !enabled {
+		return value, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{
+		resource: resource,
+		value:    value,
+		expires:  time.Now().Add(c.ttls[resource]),
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+func (c *cachedService) invalidate(resources ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		for _, resource := range resources {
+			if entry.resource == resource {
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+func (c *cachedService) Disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = false
+	c.entries = make(map[string]cacheEntry)
+}
+
+func (c *cachedService) GetRouterInfo() (tplinkapi.RouterInfo, error) {
+	return cached(c, resourceRouterInfo, resourceRouterInfo, c.RouterService.GetRouterInfo)
+}
+
+func (c *cachedService) GetLanConfig() (tplinkapi.LanConfig, error) {
+	return cached(c, resourceLanConfig, resourceLanConfig, c.RouterService.GetLanConfig)
+}
+
+func (c *cachedService) GetDhcpConfiguration() (tplinkapi.DhcpConfiguration, error) {
+	return cached(c, resourceDhcp, resourceDhcp, c.RouterService.GetDhcpConfiguration)
+}
+
+func (c *cachedService) GetStatistics() (tplinkapi.ClientStatistics, error) {
+	return cached(c, resourceStatistics, resourceStatistics, c.RouterService.GetStatistics)
+}
+
+func (c *cachedService) GetAddressReservations() ([]tplinkapi.ClientReservation, error) {
+	return cached(c, resourceReservations, resourceReservations, c.RouterService.GetAddressReservations)
+}
+
+func (c *cachedService) GetIpMacBindings() ([]tplinkapi.ClientReservation, error) {
+	return cached(c, resourceBindings, resourceBindings, c.RouterService.GetIpMacBindings)
+}
+
+func (c *cachedService) GetBandwidthControlDetails() (tplinkapi.BandwidthControlDetail, error) {
+	return cached(c, resourceBandwidth, resourceBandwidth, c.RouterService.GetBandwidthControlDetails)
+}
+
+func (c *cachedService) GetBandwidthControlEntry(id int) (tplinkapi.BandwidthControlEntry, error) {
+	key := fmt.Sprintf("%s:%d", resourceBandwidth, id)
+	return cached(c, resourceBandwidth, key, func() (tplinkapi.BandwidthControlEntry, error) {
+		return c.RouterService.GetBandwidthControlEntry(id)
+	})
+}
+
+func (c *cachedService) GetAccessControlHosts() (tplinkapi.AccessControlHostMap, error) {
+	return cached(c, resourceHosts, resourceHosts, c.RouterService.GetAccessControlHosts)
+}
+
+func (c *cachedService) GetAccessControlRules() ([]tplinkapi.AccessControlRule, error) {
+	return cached(c, resourceRules, resourceRules, c.RouterService.GetAccessControlRules)
+}
+
+func (c *cachedService) MakeIpAddressReservation(client tplinkapi.Client) error {
+	defer c.invalidate(resourceReservations, resourceBindings)
+	return c.RouterService.MakeIpAddressReservation(client)
+}
+
+func (c *cachedService) DeleteIpAddressReservation(macAddress string) error {
+	defer c.invalidate(resourceReservations, resourceBindings)
+	return c.RouterService.DeleteIpAddressReservation(macAddress)
+}
+
+func (c *cachedService) ToggleBandwidthControl(config tplinkapi.BandwidthControlDetail) error {
+	defer c.invalidate(resourceBandwidth)
+	return c.RouterService.ToggleBandwidthControl(config)
+}
+
+func (c *cachedService) AddBwControlEntry(entry tplinkapi.BandwidthControlEntry) (int, error) {
+	defer c.invalidate(resourceBandwidth)
+	return c.RouterService.AddBwControlEntry(entry)
+}
+
+func (c *cachedService) DeleteBwControlEntry(entryId int) error {
+	defer c.invalidate(resourceBandwidth)
+	return c.RouterService.DeleteBwControlEntry(entryId)
+}
+
+func (c *cachedService) ToggleInternetAccessControl(cfg tplinkapi.InternetAccessControl) error {
+	defer c.invalidate(resourceRules)
+	return c.RouterService.ToggleInternetAccessControl(cfg)
+}
+
+func (c *cachedService) AddAccessControlHost(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	defer c.invalidate(resourceHosts)
+	return c.RouterService.AddAccessControlHost(host)
+}
+
+func (c *cachedService) RemoveAccessControlHost(id int) error {
+	defer c.invalidate(resourceHosts, resourceRules)
+	return c.RouterService.RemoveAccessControlHost(id)
+}
+
+func (c *cachedService) AddAccessControlRule(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	defer c.invalidate(resourceRules)
+	return c.RouterService.AddAccessControlRule(host)
+}
+
+func (c *cachedService) DeleteAccessControlRule(id int) error {
+	defer c.invalidate(resourceRules)
+	return c.RouterService.DeleteAccessControlRule(id)
+}
+
+func (c *cachedService) UpdateDhcpConfiguration(cfg tplinkapi.DhcpConfiguration) error {
+	defer c.invalidate(resourceDhcp, resourceLanConfig)
+	return c.RouterService.UpdateDhcpConfiguration(cfg)
+}
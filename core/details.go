@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+type DeviceDetails struct {
+	Device  storage.Device
+	IP      string
+	Online  bool
+	Blocked bool
+}
+
+// SlotDetails is a slot with the number of its addresses reserved or in use
+// by an online client.
+type SlotDetails struct {
+	UserSlot
+	Size int
+	Used int
+}
+
+func (slot SlotDetails) Utilisation() float64 {
+	if slot.Size == 0 {
+		return 0
+	}
+	return float64(slot.Used) / float64(slot.Size)
+}
+
+type UserDetails struct {
+	User    storage.User
+	Groups  []storage.Group
+	Devices []DeviceDetails
+	Slots   []SlotDetails
+}
+
+func (api RouterApi) GetUserDetails(userId int) (UserDetails, error) {
+	return api.GetUserDetailsContext(context.Background(), userId)
+}
+
+// GetUserDetailsContext collects the devices and slots of a user along with
+// what the router reports about them.
+func (api RouterApi) GetUserDetailsContext(ctx context.Context, userId int) (UserDetails, error) {
+	service := api.withContext(ctx)
+	var details UserDetails
+	user, err := api.store.UserStore.ReadContext(ctx, userId)
+	if err != nil {
+		return details, err
+	}
+	details.User = user
+	details.Groups, err = api.store.GroupStore.ReadManyByUserIdContext(ctx, userId)
+	if err != nil {
+		return details, err
+	}
+
+	devices, err := api.getAllUserDevices(ctx, userId)
+	if err != nil {
+		return details, err
+	}
+	slots, err := api.getAllUserSlots(ctx, userId)
+	if err != nil {
+		return details, err
+	}
+	userSlots, err := api.withEntries(ctx, slots)
+	if err != nil {
+		return details, err
+	}
+
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return details, err
+	}
+	stats, err := service.GetStatistics()
+	if err != nil {
+		return details, err
+	}
+	blockedAddresses, err := api.getBlockedMacAddresses(ctx)
+	if err != nil {
+		return details, err
+	}
+
+	reserved := make(map[string]string, len(reservations))
+	used := make(map[netip.Addr]bool, len(reservations)+len(stats))
+	for _, resv := range reservations {
+		reserved[strings.ToUpper(resv.Mac)] = resv.IP
+		if addr, err := parseIPv4(resv.IP); err == nil {
+			used[addr] = true
+		}
+	}
+	online := make(map[string]bool, len(stats))
+	for _, stat := range stats {
+		online[strings.ToUpper(stat.Mac)] = true
+		if addr, err := parseIPv4(stat.IP); err == nil {
+			used[addr] = true
+		}
+	}
+	blocked := make(map[string]bool, len(blockedAddresses))
+	for _, mac := range blockedAddresses {
+		blocked[strings.ToUpper(mac)] = true
+	}
+
+	details.Devices = make([]DeviceDetails, 0, len(devices))
+	for _, device := range devices {
+		mac := strings.ToUpper(device.Mac)
+		details.Devices = append(details.Devices, DeviceDetails{
+			Device:  device,
+			IP:      reserved[mac],
+			Online:  online[mac],
+			Blocked: blocked[mac],
+		})
+	}
+
+	details.Slots = make([]SlotDetails, 0, len(userSlots))
+	for _, slot := range userSlots {
+		slotRange, err := NewAddrRange(slot.Entry.StartIp, slot.Entry.EndIp)
+		if err != nil {
+			return details, err
+		}
+		slotDetails := SlotDetails{UserSlot: slot, Size: slotRange.Size()}
+		for addr := range used {
+			if slotRange.Contains(addr) {
+				slotDetails.Used++
+			}
+		}
+		details.Slots = append(details.Slots, slotDetails)
+	}
+	return details, nil
+}
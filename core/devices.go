@@ -0,0 +1,439 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+func (api RouterApi) GetDevices() ([]storage.Device, error) {
+	return api.GetDevicesContext(context.Background())
+}
+
+// GetDevicesContext returns every device registered on the router.
+func (api RouterApi) GetDevicesContext(ctx context.Context) ([]storage.Device, error) {
+	devices := make([]storage.Device, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.DeviceStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, page...)
+		if len(page) < pageSizeAll {
+			return devices, nil
+		}
+	}
+}
+
+func (api RouterApi) AssignDeviceSlot(deviceId int, ip string, limits SlotLimits) (storage.BandwidthSlot, error) {
+	return api.AssignDeviceSlotContext(context.Background(), deviceId, ip, limits)
+}
+
+// AssignDeviceSlotContext gives a device a bandwidth entry of its own for a
+// single address, which belongs to the device's user. The device's
+// reservation moves to that address. When ip is empty a free address outside
+// the DHCP pool is picked.
+func (api RouterApi) AssignDeviceSlotContext(ctx context.Context, deviceId int, ip string, limits SlotLimits) (storage.BandwidthSlot, error) {
+	service := api.withContext(ctx)
+	var slot storage.BandwidthSlot
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return slot, err
+	}
+	if existing, err := api.store.BandwidthSlotStore.ReadByDeviceIdContext(ctx, deviceId); err == nil {
+		return slot, &SoftError{Message: fmt.Sprintf("Device '%s' already has bandwidth slot %d", device.Alias, existing.Id)}
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return slot, err
+	}
+	if err = validateLimits(limits, details); err != nil {
+		return slot, err
+	}
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return slot, err
+	}
+
+	var r AddrRange
+	if ip == "" {
+		r, err = api.allocateDeviceAddress(ctx, reservations, device.Mac)
+		if err != nil {
+			return slot, err
+		}
+	} else {
+		addr, err := parseIPv4(ip)
+		if err != nil {
+			return slot, &SoftError{Message: err.Error()}
+		}
+		r = SingleAddr(addr)
+	}
+	if err = api.checkSlotRange(ctx, r, slot); err != nil {
+		return slot, err
+	}
+	for _, resv := range reservations {
+		if resv.IP == r.Start.String() && !strings.EqualFold(resv.Mac, device.Mac) {
+			return slot, &SoftError{Message: fmt.Sprintf("%s is reserved for %s", resv.IP, resv.Mac)}
+		}
+	}
+
+	client, err := tplinkapi.NewClient(r.Start.String(), device.Mac)
+	if err != nil {
+		return slot, err
+	}
+	entry := tplinkapi.BandwidthControlEntry{
+		Enabled: true,
+		StartIp: client.IP,
+		EndIp:   client.IP,
+		UpMin:   limits.UpMin,
+		UpMax:   limits.UpMax,
+		DownMin: limits.DownMin,
+		DownMax: limits.DownMax,
+	}
+	id, err := service.AddBwControlEntry(entry)
+	if err != nil {
+		return slot, err
+	}
+	if err = api.moveReservation(ctx, reservations, client); err != nil {
+		service.DeleteBwControlEntry(id)
+		return slot, err
+	}
+
+	slot = storage.BandwidthSlot{UserId: device.UserId, RemoteId: id, DeviceId: device.Id}
+	setSlotEntry(&slot, entry)
+	err = api.store.BandwidthSlotStore.CreateContext(ctx, &slot)
+	return slot, err
+}
+
+// allocateDeviceAddress picks the free address in the smallest gap outside
+// the DHCP pool that no other device has reserved.
+func (api RouterApi) allocateDeviceAddress(ctx context.Context, reservations []tplinkapi.ClientReservation, mac string) (AddrRange, error) {
+	service := api.withContext(ctx)
+	available, _, err := api.getAvailableAddresses(ctx, false)
+	if err != nil {
+		return AddrRange{}, err
+	}
+	dhcpConfig, err := service.GetDhcpConfiguration()
+	if err != nil {
+		return AddrRange{}, err
+	}
+	pool, err := NewAddrRange(dhcpConfig.MinAddress, dhcpConfig.MaxAddress)
+	if err != nil {
+		return AddrRange{}, err
+	}
+	available.Remove(pool)
+	for _, resv := range reservations {
+		if strings.EqualFold(resv.Mac, mac) {
+			continue
+		}
+		if addr, err := parseIPv4(resv.IP); err == nil {
+			available.Remove(SingleAddr(addr))
+		}
+	}
+	r, err := available.Allocate(1, BestFit)
+	if errors.Is(err, ErrNoFreeAddresses) {
+		return r, &SoftError{Message: "no free address for the device"}
+	}
+	return r, err
+}
+
+// moveReservation reserves client.IP for client.Mac, replacing any existing
+// reservation of the MAC address. The old reservation is restored if the new
+// one cannot be made.
+func (api RouterApi) moveReservation(ctx context.Context, reservations []tplinkapi.ClientReservation, client tplinkapi.Client) error {
+	service := api.withContext(ctx)
+	var old *tplinkapi.ClientReservation
+	for i, resv := range reservations {
+		if !strings.EqualFold(resv.Mac, client.Mac) {
+			continue
+		}
+		if resv.IP == client.IP {
+			return nil
+		}
+		if err := service.DeleteIpAddressReservation(resv.Mac); err != nil {
+			return err
+		}
+		old = &reservations[i]
+		break
+	}
+	if err := service.MakeIpAddressReservation(client); err != nil {
+		if old != nil {
+			if restored, restoreErr := tplinkapi.NewClient(old.IP, old.Mac); restoreErr == nil {
+				service.MakeIpAddressReservation(restored)
+			}
+		}
+		return err
+	}
+	if old != nil {
+		api.logger.Info("reservation moved", "mac", client.Mac, "from", old.IP, "to", client.IP)
+	}
+	return nil
+}
+
+// releaseDeviceSlot moves the reservation of a device whose own slot is
+// removed back to a free address in one of its user's other slots. The
+// reservation is left in place when none has room.
+func (api RouterApi) releaseDeviceSlot(ctx context.Context, slot storage.BandwidthSlot) error {
+	service := api.withContext(ctx)
+	device, err := api.store.DeviceStore.ReadContext(ctx, slot.DeviceId)
+	if err != nil {
+		// The device is gone, so there is no reservation to keep.
+		return nil
+	}
+	slots, err := api.getAllUserSlots(ctx, slot.UserId)
+	if err != nil {
+		return err
+	}
+	for _, other := range slots {
+		if other.Id == slot.Id || other.DeviceId != 0 || other.StartIp == "" {
+			continue
+		}
+		r, err := NewAddrRange(other.StartIp, other.EndIp)
+		if err != nil {
+			return err
+		}
+		ip, err := api.getUnusedIPAddress(ctx, r)
+		if err != nil {
+			continue
+		}
+		client, err := tplinkapi.NewClient(ip, device.Mac)
+		if err != nil {
+			return err
+		}
+		reservations, err := service.GetAddressReservations()
+		if err != nil {
+			return err
+		}
+		return api.moveReservation(ctx, reservations, client)
+	}
+	api.logger.Warn("no room to move device reservation", "mac", device.Mac, "ip", slot.StartIp)
+	return nil
+}
+
+func (api RouterApi) ReassignDevice(deviceId, newUserId, newSlotId int) error {
+	return api.ReassignDeviceContext(context.Background(), deviceId, newUserId, newSlotId)
+}
+
+// ReassignDeviceContext gives a device to another user, or moves it to
+// another slot of the same user, reserving a free address in the new slot.
+// A bandwidth entry of the device's own is removed.
+func (api RouterApi) ReassignDeviceContext(ctx context.Context, deviceId, newUserId, newSlotId int) error {
+	service := api.withContext(ctx)
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+	if _, err = api.store.UserStore.ReadContext(ctx, newUserId); err != nil {
+		return err
+	}
+	slot, entry, err := api.getSlotEntry(ctx, newSlotId)
+	if err != nil {
+		return err
+	}
+	if slot.UserId != newUserId {
+		return &SoftError{Message: fmt.Sprintf("Slot %d does not belong to the new user", slot.Id)}
+	}
+	if slot.DeviceId != 0 {
+		return &SoftError{Message: fmt.Sprintf("Slot %d belongs to a single device", slot.Id)}
+	}
+
+	slotRange, err := NewAddrRange(entry.StartIp, entry.EndIp)
+	if err != nil {
+		return err
+	}
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return err
+	}
+	for _, resv := range reservations {
+		ip, err := parseIPv4(resv.IP)
+		if err == nil && strings.EqualFold(resv.Mac, device.Mac) && slotRange.Contains(ip) {
+			return &SoftError{Message: fmt.Sprintf("Device already has %s in slot %d", resv.IP, slot.Id)}
+		}
+	}
+	ip, err := api.getUnusedIPAddress(ctx, slotRange)
+	if err != nil {
+		return &SoftError{Message: fmt.Sprintf("Slot %d has no free address", slot.Id)}
+	}
+	client, err := tplinkapi.NewClient(ip, device.Mac)
+	if err != nil {
+		return err
+	}
+
+	if own, err := api.store.BandwidthSlotStore.ReadByDeviceIdContext(ctx, deviceId); err == nil {
+		if err = api.removeSlot(ctx, own); err != nil {
+			return err
+		}
+	}
+	if err = api.moveReservation(ctx, reservations, client); err != nil {
+		return err
+	}
+	device.UserId = newUserId
+	return api.store.DeviceStore.UpdateContext(ctx, device)
+}
+
+func (api RouterApi) RenameUser(userId int, name string) error {
+	return api.RenameUserContext(context.Background(), userId, name)
+}
+
+func (api RouterApi) RenameUserContext(ctx context.Context, userId int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &SoftError{Message: "Name is required"}
+	}
+	user, err := api.store.UserStore.ReadContext(ctx, userId)
+	if err != nil {
+		return err
+	}
+	user.Name = name
+	return api.store.UserStore.UpdateContext(ctx, user)
+}
+
+func (api RouterApi) SetDeviceAlias(deviceId int, alias string) error {
+	return api.SetDeviceAliasContext(context.Background(), deviceId, alias)
+}
+
+func (api RouterApi) SetDeviceAliasContext(ctx context.Context, deviceId int, alias string) error {
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+	device.Alias = strings.TrimSpace(alias)
+	return api.store.DeviceStore.UpdateContext(ctx, device)
+}
+
+func (api RouterApi) ChangeDeviceMac(deviceId int, mac string) error {
+	return api.ChangeDeviceMacContext(context.Background(), deviceId, mac)
+}
+
+// ChangeDeviceMacContext corrects the MAC address of a device. Its address
+// reservation and any access control host and rule move to the new address,
+// so a blocked device stays blocked.
+func (api RouterApi) ChangeDeviceMacContext(ctx context.Context, deviceId int, mac string) error {
+	service := api.withContext(ctx)
+	if !tplinkapi.IsValidMacAddress(mac) {
+		return &SoftError{Message: fmt.Sprintf("invalid mac address '%s'", mac)}
+	}
+	mac = strings.ToUpper(mac)
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+	oldMac := strings.ToUpper(device.Mac)
+	if oldMac == mac {
+		return nil
+	}
+	existing, err := api.store.DeviceStore.ReadManyByMacContext(ctx, []string{mac})
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return &SoftError{Message: fmt.Sprintf("%s belongs to device '%s'", mac, existing[0].Alias)}
+	}
+
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return err
+	}
+	moves := make([]reservationMove, 0)
+	for _, resv := range reservations {
+		if !strings.EqualFold(resv.Mac, oldMac) {
+			continue
+		}
+		client, err := tplinkapi.NewClient(resv.IP, mac)
+		if err != nil {
+			return err
+		}
+		moves = append(moves, reservationMove{client: client, mac: resv.Mac, from: resv.IP})
+	}
+	if err = api.moveReservations(ctx, moves); err != nil {
+		return err
+	}
+
+	if err = api.moveAccessControl(ctx, oldMac, mac); err != nil {
+		api.undoReservationMoves(ctx, moves)
+		return err
+	}
+	device.Mac = mac
+	return api.store.DeviceStore.UpdateContext(ctx, device)
+}
+
+// moveAccessControl replaces the access control host of oldMac, and its
+// rule if the device is blocked, with ones for newMac. The new host and rule
+// are added before the old ones are removed, and removed again if that fails.
+func (api RouterApi) moveAccessControl(ctx context.Context, oldMac, newMac string) error {
+	service := api.withContext(ctx)
+	hosts, err := service.GetAccessControlHosts()
+	if err != nil {
+		return err
+	}
+	var host tplinkapi.MacAddressAccessControlHost
+	for _, value := range hosts[tplinkapi.MacAddressHostType] {
+		if h, ok := value.(tplinkapi.MacAddressAccessControlHost); ok && strings.EqualFold(h.Mac, oldMac) {
+			host = h
+			break
+		}
+	}
+	if host.Id == 0 {
+		return nil
+	}
+
+	rules, err := service.GetAccessControlRules()
+	if err != nil {
+		return err
+	}
+	var rule tplinkapi.AccessControlRule
+	for _, r := range rules {
+		if r.InternalHostRef == host.GetRef() {
+			rule = r
+			break
+		}
+	}
+
+	newHost, err := tplinkapi.NewMacAddressAccessControlHost(newMac)
+	if err != nil {
+		return err
+	}
+	if newHost.Id, err = service.AddAccessControlHost(newHost); err != nil {
+		return err
+	}
+	newRuleId := 0
+	if rule.Id != 0 {
+		if newRuleId, err = service.AddAccessControlRule(newHost); err != nil {
+			api.undoAccessControlMove(ctx, newHost, 0)
+			return err
+		}
+		if err = service.DeleteAccessControlRule(rule.Id); err != nil {
+			api.undoAccessControlMove(ctx, newHost, newRuleId)
+			return err
+		}
+	}
+	if err = service.RemoveAccessControlHost(host.Id); err != nil {
+		if rule.Id != 0 {
+			if _, restoreErr := service.AddAccessControlRule(host); restoreErr != nil {
+				api.logger.Warn("access control rule not restored", "mac", host.Mac, "error", restoreErr)
+			}
+		}
+		api.undoAccessControlMove(ctx, newHost, newRuleId)
+		return err
+	}
+	return nil
+}
+
+// undoAccessControlMove removes the rule and host added for a new MAC by
+// moveAccessControl. A ruleId of 0 means no rule was added.
+func (api RouterApi) undoAccessControlMove(ctx context.Context, host tplinkapi.MacAddressAccessControlHost, ruleId int) {
+	service := api.withContext(ctx)
+	if ruleId != 0 {
+		if err := service.DeleteAccessControlRule(ruleId); err != nil {
+			api.logger.Warn("access control rule not removed", "mac", host.Mac, "error", err)
+		}
+	}
+	if err := service.RemoveAccessControlHost(host.Id); err != nil {
+		api.logger.Warn("access control host not removed", "mac", host.Mac, "error", err)
+	}
+}
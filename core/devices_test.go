@@ -0,0 +1,133 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+// accessControlRouter keeps access control hosts and rules, and fails to
+// delete or remove the ones listed in failRules and failHosts.
+type accessControlRouter struct {
+	RouterService
+	hosts     []tplinkapi.MacAddressAccessControlHost
+	rules     []tplinkapi.AccessControlRule
+	nextId    int
+	failRules map[int]bool
+	failHosts map[int]bool
+}
+
+var errRouterRejected = errors.New("router rejected the request")
+
+func (r *accessControlRouter) GetAddressReservations() ([]tplinkapi.ClientReservation, error) {
+	return nil, nil
+}
+
+func (r *accessControlRouter) GetAccessControlHosts() (tplinkapi.AccessControlHostMap, error) {
+	hosts := make([]interface{}, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		hosts = append(hosts, host)
+	}
+	return tplinkapi.AccessControlHostMap{tplinkapi.MacAddressHostType: hosts}, nil
+}
+
+func (r *accessControlRouter) GetAccessControlRules() ([]tplinkapi.AccessControlRule, error) {
+	return r.rules, nil
+}
+
+func (r *accessControlRouter) AddAccessControlHost(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	h := host.(tplinkapi.MacAddressAccessControlHost)
+	r.nextId++
+	h.Id = r.nextId
+	r.hosts = append(r.hosts, h)
+	return h.Id, nil
+}
+
+func (r *accessControlRouter) RemoveAccessControlHost(id int) error {
+	if r.failHosts[id] {
+		return errRouterRejected
+	}
+	for i, host := range r.hosts {
+		if host.Id == id {
+			r.hosts = append(r.hosts[:i], r.hosts[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such host")
+}
+
+func (r *accessControlRouter) AddAccessControlRule(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	r.nextId++
+	r.rules = append(r.rules, tplinkapi.AccessControlRule{Id: r.nextId, Enabled: true, InternalHostRef: host.GetRef()})
+	return r.nextId, nil
+}
+
+func (r *accessControlRouter) DeleteAccessControlRule(id int) error {
+	if r.failRules[id] {
+		return errRouterRejected
+	}
+	for i, rule := range r.rules {
+		if rule.Id == id {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such rule")
+}
+
+func TestChangeDeviceMacRollsBackAccessControl(t *testing.T) {
+	const oldMac, newMac = "AA:BB:CC:DD:EE:01", "AA:BB:CC:DD:EE:02"
+	tests := []struct {
+		name      string
+		failRules map[int]bool
+		failHosts map[int]bool
+	}{
+		{name: "old rule not deleted", failRules: map[int]bool{2: true}},
+		{name: "old host not removed", failHosts: map[int]bool{1: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := tplinkapi.NewMacAddressAccessControlHost(oldMac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host.Id = 1
+			router := &accessControlRouter{
+				hosts:     []tplinkapi.MacAddressAccessControlHost{host},
+				rules:     []tplinkapi.AccessControlRule{{Id: 2, Enabled: true, InternalHostRef: host.GetRef()}},
+				nextId:    2,
+				failRules: tt.failRules,
+				failHosts: tt.failHosts,
+			}
+			api := newTestApi(t, router)
+			user, err := api.RegisterUser("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			device := storage.Device{UserId: user.Id, Alias: "phone", Mac: oldMac}
+			if err = api.store.DeviceStore.Create(&device); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = api.ChangeDeviceMac(device.Id, newMac); !errors.Is(err, errRouterRejected) {
+				t.Fatalf("ChangeDeviceMac error = %v, want %v", err, errRouterRejected)
+			}
+
+			if len(router.hosts) != 1 || router.hosts[0].Mac != oldMac {
+				t.Errorf("router hosts = %v, want only %s", router.hosts, oldMac)
+			}
+			if len(router.rules) != 1 || router.rules[0].InternalHostRef != host.GetRef() {
+				t.Errorf("router rules = %v, want one for %s", router.rules, oldMac)
+			}
+			stored, err := api.store.DeviceStore.Read(device.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Mac != oldMac {
+				t.Errorf("stored mac = %s, want %s", stored.Mac, oldMac)
+			}
+		})
+	}
+}
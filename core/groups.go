@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+func (api RouterApi) GetGroups() ([]storage.Group, error) {
+	return api.GetGroupsContext(context.Background())
+}
+
+func (api RouterApi) GetGroupsContext(ctx context.Context) ([]storage.Group, error) {
+	groups := make([]storage.Group, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.GroupStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, page...)
+		if len(page) < pageSizeAll {
+			return groups, nil
+		}
+	}
+}
+
+func (api RouterApi) GetGroup(name string) (storage.Group, error) {
+	return api.GetGroupContext(context.Background(), name)
+}
+
+func (api RouterApi) GetGroupContext(ctx context.Context, name string) (storage.Group, error) {
+	return api.store.GroupStore.ReadByNameContext(ctx, strings.TrimSpace(name))
+}
+
+func (api RouterApi) CreateGroup(name string) (storage.Group, error) {
+	return api.CreateGroupContext(context.Background(), name)
+}
+
+func (api RouterApi) CreateGroupContext(ctx context.Context, name string) (storage.Group, error) {
+	group := storage.Group{Name: strings.TrimSpace(name)}
+	if group.Name == "" {
+		return group, &SoftError{Message: "Group name is required"}
+	}
+	if _, err := api.store.GroupStore.ReadByNameContext(ctx, group.Name); err == nil {
+		return group, &SoftError{Message: fmt.Sprintf("Group '%s' already exists", group.Name)}
+	}
+	err := api.store.GroupStore.CreateContext(ctx, &group)
+	return group, err
+}
+
+func (api RouterApi) DeleteGroup(groupId int) error {
+	return api.DeleteGroupContext(context.Background(), groupId)
+}
+
+// DeleteGroupContext removes a group. Its members and anything applied
+// through it, such as plans and schedules, are kept.
+func (api RouterApi) DeleteGroupContext(ctx context.Context, groupId int) error {
+	if _, err := api.store.GroupStore.ReadContext(ctx, groupId); err != nil {
+		return err
+	}
+	return api.store.GroupStore.DeleteContext(ctx, groupId)
+}
+
+func (api RouterApi) GetGroupMembers(groupId int) ([]storage.User, error) {
+	return api.GetGroupMembersContext(context.Background(), groupId)
+}
+
+func (api RouterApi) GetGroupMembersContext(ctx context.Context, groupId int) ([]storage.User, error) {
+	return api.store.GroupStore.ReadMembersContext(ctx, groupId)
+}
+
+func (api RouterApi) AddGroupMember(groupId, userId int) error {
+	return api.AddGroupMemberContext(context.Background(), groupId, userId)
+}
+
+func (api RouterApi) AddGroupMemberContext(ctx context.Context, groupId, userId int) error {
+	if _, err := api.store.GroupStore.ReadContext(ctx, groupId); err != nil {
+		return err
+	}
+	if _, err := api.store.UserStore.ReadContext(ctx, userId); err != nil {
+		return err
+	}
+	return api.store.GroupStore.AddMemberContext(ctx, groupId, userId)
+}
+
+func (api RouterApi) RemoveGroupMember(groupId, userId int) error {
+	return api.RemoveGroupMemberContext(context.Background(), groupId, userId)
+}
+
+func (api RouterApi) RemoveGroupMemberContext(ctx context.Context, groupId, userId int) error {
+	return api.store.GroupStore.RemoveMemberContext(ctx, groupId, userId)
+}
+
+// groupMembers returns the members of a group, failing with a SoftError
+// when it has none.
+func (api RouterApi) groupMembers(ctx context.Context, groupId int) ([]storage.User, error) {
+	group, err := api.store.GroupStore.ReadContext(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	members, err := api.store.GroupStore.ReadMembersContext(ctx, groupId)
+	if err != nil {
+		return members, err
+	}
+	if len(members) == 0 {
+		return members, &SoftError{Message: fmt.Sprintf("Group '%s' has no members", group.Name)}
+	}
+	return members, nil
+}
+
+func (api RouterApi) groupDevices(ctx context.Context, groupId int) ([]storage.Device, error) {
+	members, err := api.groupMembers(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]storage.Device, 0)
+	for _, member := range members {
+		userDevices, err := api.getAllUserDevices(ctx, member.Id)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, userDevices...)
+	}
+	return devices, nil
+}
+
+func (api RouterApi) groupSlots(ctx context.Context, groupId int) ([]storage.BandwidthSlot, error) {
+	members, err := api.groupMembers(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]storage.BandwidthSlot, 0)
+	for _, member := range members {
+		userSlots, err := api.getAllUserSlots(ctx, member.Id)
+		if err != nil {
+			return slots, err
+		}
+		slots = append(slots, userSlots...)
+	}
+	return slots, nil
+}
+
+func (api RouterApi) SetGroupBlocked(groupId int, blocked bool) (int, error) {
+	return api.SetGroupBlockedContext(context.Background(), groupId, blocked)
+}
+
+// SetGroupBlockedContext blocks or unblocks every device of the group's
+// members and returns the number of devices changed.
+func (api RouterApi) SetGroupBlockedContext(ctx context.Context, groupId int, blocked bool) (int, error) {
+	devices, err := api.groupDevices(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	return api.setDevicesBlocked(ctx, devices, blocked)
+}
+
+func (api RouterApi) SetGroupPlan(groupId int, planName string) (int, error) {
+	return api.SetGroupPlanContext(context.Background(), groupId, planName)
+}
+
+// SetGroupPlanContext applies a plan to every slot of the group's members.
+// Entries of single devices keep their own limits. It returns the number of
+// slots changed.
+func (api RouterApi) SetGroupPlanContext(ctx context.Context, groupId int, planName string) (int, error) {
+	if _, err := api.GetPlanContext(ctx, planName); err != nil {
+		return 0, err
+	}
+	slots, err := api.groupSlots(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, slot := range slots {
+		if slot.DeviceId != 0 {
+			continue
+		}
+		if err = api.SetSlotPlanContext(ctx, slot.Id, planName); err != nil {
+			return changed, fmt.Errorf("slot %d: %w", slot.Id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func (api RouterApi) AddGroupSchedule(groupId int, schedule storage.SlotSchedule) ([]storage.SlotSchedule, error) {
+	return api.AddGroupScheduleContext(context.Background(), groupId, schedule)
+}
+
+// AddGroupScheduleContext adds the schedule to every slot of the group's
+// members. Slots without stored limits are skipped. Members added later do
+// not get the schedule.
+func (api RouterApi) AddGroupScheduleContext(ctx context.Context, groupId int, schedule storage.SlotSchedule) ([]storage.SlotSchedule, error) {
+	schedules := make([]storage.SlotSchedule, 0)
+	slots, err := api.groupSlots(ctx, groupId)
+	if err != nil {
+		return schedules, err
+	}
+	for _, slot := range slots {
+		if slot.StartIp == "" {
+			api.logger.Warn("slot without stored limits skipped", "slot", slot.Id)
+			continue
+		}
+		slotSchedule := schedule
+		slotSchedule.SlotId = slot.Id
+		slotSchedule, err = api.AddSlotScheduleContext(ctx, slotSchedule)
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, slotSchedule)
+	}
+	if len(schedules) == 0 {
+		return schedules, &SoftError{Message: "No slots of the group can take a schedule"}
+	}
+	return schedules, nil
+}
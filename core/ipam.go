@@ -0,0 +1,346 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+// DhcpPoolWarningThreshold is the share of the dynamic pool in use above
+// which the pool is reported as nearly exhausted.
+const DhcpPoolWarningThreshold = 0.9
+
+// AddressSegment is a run of consecutive addresses with the same uses. A
+// segment without uses is free.
+type AddressSegment struct {
+	Start string
+	End   string
+	Size  int
+	Uses  []string
+}
+
+func (segment AddressSegment) IsFree() bool {
+	return len(segment.Uses) == 0
+}
+
+type AddressAssignment struct {
+	IP       string
+	Mac      string
+	Alias    string
+	User     string
+	Reserved bool
+	Online   bool
+}
+
+type IpamReport struct {
+	Network            string
+	RouterIP           string
+	UsableAddresses    int
+	PoolStart          string
+	PoolEnd            string
+	PoolSize           int
+	PoolUsed           int
+	BandwidthAddresses int
+	FreeAddresses      int
+	Segments           []AddressSegment
+	Assignments        []AddressAssignment
+}
+
+func (report IpamReport) Utilisation() float64 {
+	if report.UsableAddresses == 0 {
+		return 0
+	}
+	return float64(report.UsableAddresses-report.FreeAddresses) / float64(report.UsableAddresses)
+}
+
+func (report IpamReport) PoolUtilisation() float64 {
+	if report.PoolSize == 0 {
+		return 0
+	}
+	return float64(report.PoolUsed) / float64(report.PoolSize)
+}
+
+func (report IpamReport) PoolNearlyExhausted() bool {
+	return report.PoolSize > 0 && report.PoolUtilisation() >= DhcpPoolWarningThreshold
+}
+
+type labelledRange struct {
+	AddrRange
+	use string
+}
+
+func (api RouterApi) GetIpamReport() (IpamReport, error) {
+	return api.GetIpamReportContext(context.Background())
+}
+
+func (api RouterApi) GetIpamReportContext(ctx context.Context) (IpamReport, error) {
+	service := api.withContext(ctx)
+	var report IpamReport
+
+	info, err := service.GetRouterInfo()
+	if err != nil {
+		return report, err
+	}
+	routerAddr, err := parseIPv4(info.IP)
+	if err != nil {
+		return report, err
+	}
+	lanConfig, err := service.GetLanConfig()
+	if err != nil {
+		return report, err
+	}
+	prefix := netip.PrefixFrom(routerAddr, lanConfig.GetPrefix())
+	hosts, err := hostRange(prefix)
+	if err != nil {
+		return report, err
+	}
+	report.Network = prefix.Masked().String()
+	report.RouterIP = info.IP
+	report.UsableAddresses = hosts.Size()
+
+	ranges := []labelledRange{{AddrRange: SingleAddr(routerAddr), use: "router"}}
+
+	dhcpConfig, err := service.GetDhcpConfiguration()
+	if err != nil {
+		return report, err
+	}
+	poolStart, err := parseIPv4(dhcpConfig.MinAddress)
+	if err != nil {
+		return report, err
+	}
+	poolEnd, err := parseIPv4(dhcpConfig.MaxAddress)
+	if err != nil {
+		return report, err
+	}
+	// An inverted pool contains no addresses.
+	pool := AddrRange{Start: poolStart, End: poolEnd}
+	report.PoolStart, report.PoolEnd = dhcpConfig.MinAddress, dhcpConfig.MaxAddress
+	if !poolEnd.Less(poolStart) {
+		report.PoolSize = pool.Size()
+		ranges = append(ranges, labelledRange{AddrRange: pool, use: "dhcp pool"})
+	}
+
+	entryUsers, err := api.getEntryUserNames(ctx)
+	if err != nil {
+		return report, err
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return report, err
+	}
+	addEntry := func(entry tplinkapi.BandwidthControlEntry, owner string) error {
+		entryRange, err := NewAddrRange(entry.StartIp, entry.EndIp)
+		if err != nil {
+			return err
+		}
+		use := "bandwidth: " + owner
+		if !entry.Enabled {
+			use += " (disabled)"
+		}
+		report.BandwidthAddresses += entryRange.Size()
+		ranges = append(ranges, labelledRange{AddrRange: entryRange, use: use})
+		return nil
+	}
+	for _, entry := range details.Entries {
+		owner, ok := entryUsers[entry.Id]
+		if !ok {
+			owner = fmt.Sprintf("entry #%d", entry.Id)
+		}
+		if err = addEntry(entry, owner); err != nil {
+			return report, err
+		}
+	}
+
+	userNames, err := api.getUserNames(ctx)
+	if err != nil {
+		return report, err
+	}
+	disabled, err := api.getDisabledSlots(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, slot := range disabled {
+		if err = addEntry(slotEntry(slot), userNames[slot.UserId]); err != nil {
+			return report, err
+		}
+	}
+
+	report.Assignments, err = api.getAddressAssignments(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, assignment := range report.Assignments {
+		addr, err := parseIPv4(assignment.IP)
+		if err != nil {
+			return report, err
+		}
+		if pool.Contains(addr) {
+			report.PoolUsed++
+		}
+		if coveredBy(ranges, addr) {
+			continue
+		}
+		use := "leased: " + assignment.Mac
+		if assignment.Reserved {
+			use = "reserved: " + assignment.Mac
+		}
+		ranges = append(ranges, labelledRange{AddrRange: SingleAddr(addr), use: use})
+	}
+
+	report.Segments = segmentRanges(hosts, ranges)
+	for _, segment := range report.Segments {
+		if segment.IsFree() {
+			report.FreeAddresses += segment.Size
+		}
+	}
+	return report, nil
+}
+
+func coveredBy(ranges []labelledRange, addr netip.Addr) bool {
+	for _, r := range ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentRanges splits hosts at every range boundary and merges
+// neighbouring pieces that have the same uses.
+func segmentRanges(hosts AddrRange, ranges []labelledRange) []AddressSegment {
+	first, last := addrToUint32(hosts.Start), addrToUint32(hosts.End)
+	boundaries := []uint64{uint64(first), uint64(last) + 1}
+	for _, r := range ranges {
+		boundaries = append(boundaries, uint64(addrToUint32(r.Start)), uint64(addrToUint32(r.End))+1)
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i] < boundaries[j]
+	})
+
+	segments := make([]AddressSegment, 0)
+	for i := 0; i < len(boundaries)-1; i++ {
+		start, next := boundaries[i], boundaries[i+1]
+		if start == next || start < uint64(first) || next > uint64(last)+1 {
+			continue
+		}
+		startAddr, endAddr := uint32ToAddr(uint32(start)), uint32ToAddr(uint32(next-1))
+		uses := make([]string, 0)
+		for _, r := range ranges {
+			if r.Contains(startAddr) && r.Contains(endAddr) {
+				uses = append(uses, r.use)
+			}
+		}
+
+		end := endAddr.String()
+		size := int(next - start)
+		if n := len(segments); n > 0 && strings.Join(segments[n-1].Uses, ",") == strings.Join(uses, ",") {
+			segments[n-1].End = end
+			segments[n-1].Size += size
+			continue
+		}
+		segments = append(segments, AddressSegment{
+			Start: startAddr.String(),
+			End:   end,
+			Size:  size,
+			Uses:  uses,
+		})
+	}
+	return segments
+}
+
+func (api RouterApi) getUserNames(ctx context.Context) (map[int]string, error) {
+	names := make(map[int]string)
+	users, err := api.getAllUsers(ctx)
+	if err != nil {
+		return names, err
+	}
+	for _, user := range users {
+		names[user.Id] = user.Name
+	}
+	return names, nil
+}
+
+// getEntryUserNames maps the ids of router entries to the names of the users
+// whose slots they belong to.
+func (api RouterApi) getEntryUserNames(ctx context.Context) (map[int]string, error) {
+	names := make(map[int]string)
+	userNames, err := api.getUserNames(ctx)
+	if err != nil {
+		return names, err
+	}
+	slots, err := api.getAllSlots(ctx)
+	if err != nil {
+		return names, err
+	}
+	for _, slot := range slots {
+		if slot.Enabled {
+			names[slot.RemoteId] = userNames[slot.UserId]
+		}
+	}
+	return names, nil
+}
+
+// getAddressAssignments combines static reservations with the addresses of
+// connected clients, sorted by address.
+func (api RouterApi) getAddressAssignments(ctx context.Context) ([]AddressAssignment, error) {
+	service := api.withContext(ctx)
+	assignments := make([]AddressAssignment, 0)
+	byIp := make(map[string]int)
+
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return assignments, err
+	}
+	for _, resv := range reservations {
+		byIp[resv.IP] = len(assignments)
+		assignments = append(assignments, AddressAssignment{IP: resv.IP, Mac: strings.ToUpper(resv.Mac), Reserved: true})
+	}
+
+	stats, err := service.GetStatistics()
+	if err != nil {
+		return assignments, err
+	}
+	for _, stat := range stats {
+		if i, ok := byIp[stat.IP]; ok {
+			assignments[i].Online = true
+			continue
+		}
+		byIp[stat.IP] = len(assignments)
+		assignments = append(assignments, AddressAssignment{IP: stat.IP, Mac: strings.ToUpper(stat.Mac), Online: true})
+	}
+
+	macAddresses := make([]string, 0, len(assignments))
+	for _, assignment := range assignments {
+		macAddresses = append(macAddresses, assignment.Mac)
+	}
+	devices, err := api.store.DeviceStore.ReadManyByMacContext(ctx, macAddresses)
+	if err != nil {
+		return assignments, err
+	}
+	devicesByMac := make(map[string]storage.Device)
+	for _, device := range devices {
+		devicesByMac[strings.ToUpper(device.Mac)] = device
+	}
+	for i, assignment := range assignments {
+		device, ok := devicesByMac[assignment.Mac]
+		if !ok {
+			continue
+		}
+		assignments[i].Alias = device.Alias
+		if user, err := device.GetUser(api.store.UserStore); err == nil {
+			assignments[i].User = user.Name
+		}
+	}
+
+	sort.Slice(assignments, func(i, j int) bool {
+		a, _ := parseIPv4(assignments[i].IP)
+		b, _ := parseIPv4(assignments[j].IP)
+		return a.Less(b)
+	})
+	return assignments, nil
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var redactedKeys = map[string]bool{
+	"password":      true,
+	"passphrase":    true,
+	"authorization": true,
+	"cookie":        true,
+}
+
+// redact hides attributes named after secrets wherever they are logged.
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, "[redacted]")
+	}
+	return attr
+}
+
+func NewLogger(out io.Writer, level, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s'", level)
+	}
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redact}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(out, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s'", format)
+	}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Tracer logs every router call with its arguments and parsed result at
+// debug level. tplinkapi builds its own HTTP client for each request, so
+// the raw HTTP exchange, cookies included, is out of reach and not logged.
+type Tracer struct {
+	logger *slog.Logger
+}
+
+func (t *Tracer) Intercept(call Call, next func() (interface{}, error)) (interface{}, error) {
+	if !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return next()
+	}
+
+	attrs := []any{slog.String("call", call.Name), slog.Bool("write", call.Write)}
+	if len(call.Args) > 0 {
+		attrs = append(attrs, slog.String("args", fmt.Sprintf("%+v", call.Args)))
+	}
+	t.logger.Debug("router request", attrs...)
+
+	start := time.Now()
+	result, err := next()
+
+	attrs = []any{slog.String("call", call.Name), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else if result != nil {
+		attrs = append(attrs, slog.String("response", fmt.Sprintf("%+v", result)))
+	}
+	t.logger.Debug("router response", attrs...)
+	return result, err
+}
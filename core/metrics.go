@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+type CallStats struct {
+	Count    int
+	Errors   int
+	Duration time.Duration
+}
+
+// CallMetrics counts the requests that actually reach the router, so cached
+// responses are not included.
+type CallMetrics struct {
+	mu    sync.Mutex
+	calls map[string]CallStats
+}
+
+func NewCallMetrics() *CallMetrics {
+	return &CallMetrics{calls: make(map[string]CallStats)}
+}
+
+func (m *CallMetrics) Intercept(call Call, next func() (interface{}, error)) (interface{}, error) {
+	start := time.Now()
+	result, err := next()
+	elapsed := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.calls[call.Name]
+	stats.Count++
+	stats.Duration += elapsed
+	if err != nil {
+		stats.Errors++
+	}
+	m.calls[call.Name] = stats
+	return result, err
+}
+
+func (m *CallMetrics) Snapshot() map[string]CallStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]CallStats, len(m.calls))
+	for name, stats := range m.calls {
+		snapshot[name] = stats
+	}
+	return snapshot
+}
+
+type ClientMetric struct {
+	tplinkapi.ClientStat
+	Alias string
+	User  string
+}
+
+type SlotMetric struct {
+	tplinkapi.BandwidthControlEntry
+	User string
+}
+
+type RouterMetrics struct {
+	Clients        []ClientMetric
+	Slots          []SlotMetric
+	BlockedDevices int
+	DhcpPoolSize   int
+	DhcpPoolUsed   int
+}
+
+func (api RouterApi) CallStats() map[string]CallStats {
+	return api.metrics.Snapshot()
+}
+
+func (api RouterApi) GetMetrics() (RouterMetrics, error) {
+	return api.GetMetricsContext(context.Background())
+}
+
+func (api RouterApi) GetMetricsContext(ctx context.Context) (RouterMetrics, error) {
+	service := api.withContext(ctx)
+	var metrics RouterMetrics
+
+	stats, devices, err := api.GetConnectedDevicesContext(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	devicesByMac := make(map[string]storage.Device)
+	for _, device := range devices {
+		devicesByMac[strings.ToUpper(device.Mac)] = device
+	}
+	for _, stat := range stats {
+		metric := ClientMetric{ClientStat: stat}
+		if device, ok := devicesByMac[strings.ToUpper(stat.Mac)]; ok {
+			metric.Alias = device.Alias
+			if user, err := device.GetUser(api.store.UserStore); err == nil {
+				metric.User = user.Name
+			}
+		}
+		metrics.Clients = append(metrics.Clients, metric)
+	}
+
+	slotUsers, err := api.getEntryUserNames(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return metrics, err
+	}
+	for _, entry := range details.Entries {
+		metrics.Slots = append(metrics.Slots, SlotMetric{BandwidthControlEntry: entry, User: slotUsers[entry.Id]})
+	}
+	userNames, err := api.getUserNames(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	disabled, err := api.getDisabledSlots(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	for _, slot := range disabled {
+		metrics.Slots = append(metrics.Slots, SlotMetric{BandwidthControlEntry: slotEntry(slot), User: userNames[slot.UserId]})
+	}
+
+	blocked, err := api.getBlockedMacAddresses(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	metrics.BlockedDevices = len(blocked)
+
+	dhcpConfig, err := service.GetDhcpConfiguration()
+	if err != nil {
+		return metrics, err
+	}
+	minAddress, err := parseIPv4(dhcpConfig.MinAddress)
+	if err != nil {
+		return metrics, err
+	}
+	maxAddress, err := parseIPv4(dhcpConfig.MaxAddress)
+	if err != nil {
+		return metrics, err
+	}
+	// An inverted pool contains no addresses.
+	pool := AddrRange{Start: minAddress, End: maxAddress}
+	if !maxAddress.Less(minAddress) {
+		metrics.DhcpPoolSize = pool.Size()
+	}
+	for _, stat := range stats {
+		if addr, err := parseIPv4(stat.IP); err == nil && pool.Contains(addr) {
+			metrics.DhcpPoolUsed++
+		}
+	}
+
+	sort.Slice(metrics.Clients, func(i, j int) bool {
+		return metrics.Clients[i].IpAsInt() < metrics.Clients[j].IpAsInt()
+	})
+	return metrics, nil
+}
@@ -1,12 +1,16 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"sort"
+	"log/slog"
+	"net/netip"
 	"strings"
 
+	"github.com/omushpapa/routerman/credentials"
 	"github.com/omushpapa/routerman/storage"
 	"github.com/omushpapa/tplinkapi"
 )
@@ -26,186 +30,265 @@ func (ctx Context) Set(key string, value int) {
 }
 
 type Env struct {
-	In     io.Reader
-	Out    io.Writer
-	Router *RouterApi
-	Store  *storage.Store
-	Ctx    Context
+	In      io.Reader
+	Out     io.Writer
+	Router  *RouterApi
+	Store   *storage.Store
+	Ctx     Context
+	Context context.Context
+	Logger  *slog.Logger
 }
 
 func NewEnv(in io.Reader, out io.Writer, db *sql.DB, router *RouterApi) *Env {
-	store := storage.NewStore(db)
+	store := storage.NewStore(db, router.Name())
 
 	return &Env{
-		In:     in,
-		Out:    out,
-		Router: router,
-		Store:  store,
-		Ctx:    make(Context),
+		In:      in,
+		Out:     out,
+		Router:  router,
+		Store:   store,
+		Ctx:     make(Context),
+		Context: context.Background(),
+		Logger:  router.logger,
 	}
 }
 
+func (env *Env) SetLogger(logger *slog.Logger) {
+	env.Logger = logger
+	env.Router.SetLogger(logger)
+}
+
 type BwSlot struct {
 	tplinkapi.LanConfig
 }
 
+func newBwSlot(r AddrRange, subnetMask string) (BwSlot, error) {
+	cfg, err := tplinkapi.NewLanConfig(r.Start.String(), r.End.String(), subnetMask)
+	return BwSlot{LanConfig: cfg}, err
+}
+
 func (slot BwSlot) GetCapacity() (int, error) {
-	start, err := tplinkapi.Ip2Int(slot.MinAddress)
+	r, err := NewAddrRange(slot.MinAddress, slot.MaxAddress)
 	if err != nil {
 		return 0, err
 	}
-	stop, err := tplinkapi.Ip2Int(slot.MaxAddress)
-	if err != nil {
-		return 0, err
-	}
-	capacity := int(stop-start) + 1
-	return capacity, nil
+	return r.Size(), nil
 }
 
+// GetMaxIP returns the last address of a block of numAddresses starting at
+// startIP.
 func (slot BwSlot) GetMaxIP(startIP string, numAddresses int) (string, error) {
-	var maxIP string
-	start, err := tplinkapi.Ip2Int(startIP)
+	start, err := parseIPv4(startIP)
 	if err != nil {
-		return maxIP, err
+		return "", err
 	}
-	end := start + uint32(numAddresses)
-	maxIP = tplinkapi.Int2ip(end).String()
-	return maxIP, err
+	return uint32ToAddr(addrToUint32(start) + uint32(numAddresses) - 1).String(), nil
 }
 
 type RouterApi struct {
-	service tplinkapi.RouterService
-	store   *storage.Store
+	name       string
+	service    RouterService
+	session    *Session
+	resilience *Resilience
+	tracer     *Tracer
+	metrics    *CallMetrics
+	cache      *cachedService
+	store      *storage.Store
+	logger     *slog.Logger
+	dryRun     bool
 }
 
-func NewRouterApi(username, password, address string, db *sql.DB) *RouterApi {
-	store := storage.NewStore(db)
+func NewRouterApi(name, address string, provider credentials.Provider, db *sql.DB) (*RouterApi, error) {
+	store := storage.NewStore(db, name)
+
+	creds, err := provider.Credentials()
+	if errors.Is(err, credentials.ErrNotFound) {
+		return nil, fmt.Errorf("no credentials for router '%s', run 'routerman login' or set ROUTERMAN_PASSWORD", name)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	service := tplinkapi.RouterService{
-		Username: username,
-		Password: password,
+		Username: creds.Username,
+		Password: creds.Password,
 		Address:  address,
 	}
-	return &RouterApi{service: service, store: store}
+	logger := discardLogger()
+	tracer := &Tracer{logger: logger}
+	metrics := NewCallMetrics()
+	session := NewSession(
+		withInterceptor(withInterceptor(service, tracer), metrics),
+		DefaultSessionIdleTimeout,
+	)
+	resilience := NewResilience(DefaultResiliencePolicy)
+	cache := newCachedService(
+		withInterceptor(withInterceptor(session.service, session), resilience),
+		DefaultCacheTTLs,
+	)
+	return &RouterApi{
+		name:       name,
+		service:    cache,
+		session:    session,
+		resilience: resilience,
+		tracer:     tracer,
+		metrics:    metrics,
+		cache:      cache,
+		store:      store,
+		logger:     logger,
+	}, nil
+}
+
+func (api *RouterApi) SetLogger(logger *slog.Logger) {
+	api.logger = logger
+	api.tracer.logger = logger
+	api.session.logger = logger
+	api.resilience.logger = logger
+}
+
+func (api RouterApi) SetResiliencePolicy(policy ResiliencePolicy) {
+	api.resilience.SetPolicy(policy)
+}
+
+func (api RouterApi) DisableCache() {
+	api.cache.Disable()
+}
+
+func (api RouterApi) Close() error {
+	return api.session.Close()
+}
+
+func (api RouterApi) Name() string {
+	return api.name
+}
+
+func (api *RouterApi) EnableDryRun(out io.Writer) {
+	api.dryRun = true
+	api.service = dryRunService{RouterService: api.service, out: out}
+	api.store = &storage.Store{
+		UserStore:          dryRunUserStore{UserStorage: api.store.UserStore, out: out},
+		DeviceStore:        dryRunDeviceStore{DeviceStorage: api.store.DeviceStore, out: out},
+		BandwidthSlotStore: dryRunBandwidthSlotStore{BandwidthSlotStorage: api.store.BandwidthSlotStore, out: out},
+		PlanStore:          dryRunPlanStore{PlanStorage: api.store.PlanStore, out: out},
+		ScheduleStore:      dryRunScheduleStore{SlotScheduleStorage: api.store.ScheduleStore, out: out},
+		GroupStore:         dryRunGroupStore{GroupStorage: api.store.GroupStore, out: out},
+	}
+}
+
+func (api RouterApi) IsDryRun() bool {
+	return api.dryRun
 }
 
 func (api RouterApi) GetAvailableBandwidthSlots(useDhcpBounds bool) ([]BwSlot, error) {
-	var (
-		slots   []BwSlot
-		startIp uint32
-		endIp   uint32
-		err     error
-	)
-	info, err := api.service.GetRouterInfo()
+	return api.GetAvailableBandwidthSlotsContext(context.Background(), useDhcpBounds)
+}
+
+func (api RouterApi) GetAvailableBandwidthSlotsContext(ctx context.Context, useDhcpBounds bool) ([]BwSlot, error) {
+	var slots []BwSlot
+	available, subnetMask, err := api.getAvailableAddresses(ctx, useDhcpBounds)
 	if err != nil {
 		return slots, err
 	}
-	routerIp, err := tplinkapi.Ip2Int(info.IP)
+	for _, r := range available.Ranges() {
+		slot, err := newBwSlot(r, subnetMask)
+		if err != nil {
+			return slots, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+func (api RouterApi) AllocateBandwidthSlot(numDevices int, strategy AllocationStrategy, useDhcpBounds bool) (BwSlot, error) {
+	return api.AllocateBandwidthSlotContext(context.Background(), numDevices, strategy, useDhcpBounds)
+}
+
+// AllocateBandwidthSlotContext finds a free block of exactly numDevices
+// addresses that can be passed to AssignSlot.
+func (api RouterApi) AllocateBandwidthSlotContext(ctx context.Context, numDevices int, strategy AllocationStrategy, useDhcpBounds bool) (BwSlot, error) {
+	available, subnetMask, err := api.getAvailableAddresses(ctx, useDhcpBounds)
 	if err != nil {
-		return slots, err
+		return BwSlot{}, err
+	}
+	block, err := available.Allocate(numDevices, strategy)
+	if errors.Is(err, ErrNoFreeAddresses) {
+		return BwSlot{}, &SoftError{Message: fmt.Sprintf("no free block for %d devices", numDevices)}
 	}
-	lanConfig, err := api.service.GetLanConfig()
 	if err != nil {
-		return slots, err
+		return BwSlot{}, err
 	}
-	details, err := api.service.GetBandwidthControlDetails()
+	return newBwSlot(block, subnetMask)
+}
+
+// getAvailableAddresses returns the addresses not taken by the router or an
+// enabled bandwidth entry, along with the subnet mask of the LAN.
+func (api RouterApi) getAvailableAddresses(ctx context.Context, useDhcpBounds bool) (AddrSet, string, error) {
+	service := api.withContext(ctx)
+	var available AddrSet
+
+	info, err := service.GetRouterInfo()
 	if err != nil {
-		return slots, err
+		return available, "", err
 	}
-	if useDhcpBounds {
-		startIp, err = tplinkapi.Ip2Int(lanConfig.MinAddress)
-		if err != nil {
-			return slots, err
-		}
-		endIp, err = tplinkapi.Ip2Int(lanConfig.MaxAddress)
-		if err != nil {
-			return slots, err
-		}
-	} else {
-		startIp = routerIp + 1
-		prefix := lanConfig.GetPrefix()
-		if prefix == 0 {
-			return slots, fmt.Errorf("invalid subnet prefix '%d'", prefix)
-		}
-		networkSize := 1 << (32 - prefix)
-		endIp = startIp + (uint32(networkSize) - 3)
+	routerAddr, err := parseIPv4(info.IP)
+	if err != nil {
+		return available, "", err
+	}
+	lanConfig, err := service.GetLanConfig()
+	if err != nil {
+		return available, "", err
 	}
-	allIps := make(map[uint32]bool)
-	for i := startIp; i < endIp+1; i++ {
-		allIps[i] = true
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return available, "", err
 	}
 
-	_, exists := allIps[routerIp]
-	if exists {
-		delete(allIps, routerIp)
+	var bounds AddrRange
+	if useDhcpBounds {
+		bounds, err = NewAddrRange(lanConfig.MinAddress, lanConfig.MaxAddress)
+	} else {
+		bounds, err = hostRange(netip.PrefixFrom(routerAddr, lanConfig.GetPrefix()))
+	}
+	if err != nil {
+		return available, "", err
 	}
 
+	available.Add(bounds)
+	available.Remove(SingleAddr(routerAddr))
 	for _, entry := range details.Entries {
 		if !entry.Enabled {
 			continue
 		}
-
-		start, err := tplinkapi.Ip2Int(entry.StartIp)
+		used, err := NewAddrRange(entry.StartIp, entry.EndIp)
 		if err != nil {
-			return slots, err
-		}
-		end, err := tplinkapi.Ip2Int(entry.EndIp)
-		if err != nil {
-			return slots, err
-		}
-		for i := start; i < end+1; i++ {
-			_, exists := allIps[i]
-			if exists {
-				delete(allIps, i)
-			}
+			return available, "", err
 		}
+		available.Remove(used)
 	}
 
-	ipList := make([]uint32, 0)
-	for k := range allIps {
-		ipList = append(ipList, k)
+	// Disabled slots keep their range for when they are enabled again.
+	disabled, err := api.getDisabledSlots(ctx)
+	if err != nil {
+		return available, "", err
 	}
-	sort.Slice(ipList, func(i, j int) bool {
-		return ipList[i] < ipList[j]
-	})
-
-	var slot BwSlot
-	for i, item := range ipList {
-		itemString := tplinkapi.Int2ip(item).String()
-
-		if i == 0 {
-			start := itemString
-			cfg, err := tplinkapi.NewLanConfig(start, start, lanConfig.SubnetMask)
-			if err != nil {
-				return slots, err
-			}
-
-			slot = BwSlot{LanConfig: cfg}
-		} else {
-			prevItem := ipList[i-1]
-			if prevItem == item-1 {
-				slot.MaxAddress = itemString
-			} else {
-				slots = append(slots, slot)
-				cfg, err := tplinkapi.NewLanConfig(itemString, itemString, lanConfig.SubnetMask)
-				if err != nil {
-					return slots, err
-				}
-
-				slot = BwSlot{LanConfig: cfg}
-			}
-
-			if i == len(ipList)-1 {
-				slots = append(slots, slot)
-			}
+	for _, slot := range disabled {
+		reserved, err := NewAddrRange(slot.StartIp, slot.EndIp)
+		if err != nil {
+			return available, "", err
 		}
+		available.Remove(reserved)
 	}
-	return slots, nil
+	return available, lanConfig.SubnetMask, nil
 }
 
 func (api RouterApi) GetBwControlEntriesByList(ids []int) ([]tplinkapi.BandwidthControlEntry, error) {
+	return api.GetBwControlEntriesByListContext(context.Background(), ids)
+}
+
+func (api RouterApi) GetBwControlEntriesByListContext(ctx context.Context, ids []int) ([]tplinkapi.BandwidthControlEntry, error) {
+	service := api.withContext(ctx)
 	entries := make([]tplinkapi.BandwidthControlEntry, 0)
-	details, err := api.service.GetBandwidthControlDetails()
+	details, err := service.GetBandwidthControlDetails()
 	if err != nil {
 		return entries, err
 	}
@@ -224,50 +307,51 @@ func (api RouterApi) GetBwControlEntriesByList(ids []int) ([]tplinkapi.Bandwidth
 }
 
 func (api RouterApi) GetUnusedIPAddress(slotId int) (string, error) {
-	entry, err := api.service.GetBandwidthControlEntry(slotId)
-	if err != nil {
-		return "", err
-	}
-	reservations, err := api.service.GetAddressReservations()
+	return api.GetUnusedIPAddressContext(context.Background(), slotId)
+}
+
+func (api RouterApi) GetUnusedIPAddressContext(ctx context.Context, slotId int) (string, error) {
+	service := api.withContext(ctx)
+	entry, err := service.GetBandwidthControlEntry(slotId)
 	if err != nil {
 		return "", err
 	}
-	startIpInt, err := tplinkapi.Ip2Int(entry.StartIp)
+	entryRange, err := NewAddrRange(entry.StartIp, entry.EndIp)
 	if err != nil {
 		return "", err
 	}
-	endIpInt, err := tplinkapi.Ip2Int(entry.EndIp)
+	return api.getUnusedIPAddress(ctx, entryRange)
+}
+
+func (api RouterApi) getUnusedIPAddress(ctx context.Context, r AddrRange) (string, error) {
+	service := api.withContext(ctx)
+	reservations, err := service.GetAddressReservations()
 	if err != nil {
 		return "", err
 	}
-	ipRange := make(map[uint32]bool, 0)
-	for i := startIpInt; i <= endIpInt; i++ {
-		ipRange[i] = true
-	}
+
+	var free AddrSet
+	free.Add(r)
 	for _, resv := range reservations {
-		ipInt, err := tplinkapi.Ip2Int(resv.IP)
+		addr, err := parseIPv4(resv.IP)
 		if err != nil {
 			return "", err
 		}
-		_, exists := ipRange[ipInt]
-		if exists {
-			delete(ipRange, ipInt)
-		}
+		free.Remove(SingleAddr(addr))
 	}
-	validIps := make([]uint32, 0)
-	for k := range ipRange {
-		validIps = append(validIps, k)
-	}
-	sort.Slice(validIps, func(i, j int) bool {
-		return validIps[i] < validIps[j]
-	})
-	if len(validIps) == 0 {
+	ip, err := free.Allocate(1, FirstFit)
+	if err != nil {
 		return "", fmt.Errorf("no ip addresses available")
 	}
-	return tplinkapi.Int2ip(validIps[0]).String(), err
+	return ip.Start.String(), nil
 }
 
 func (api RouterApi) BlockDevice(macAddress string) error {
+	return api.BlockDeviceContext(context.Background(), macAddress)
+}
+
+func (api RouterApi) BlockDeviceContext(ctx context.Context, macAddress string) error {
+	service := api.withContext(ctx)
 	if !tplinkapi.IsValidMacAddress(macAddress) {
 		return fmt.Errorf("invalid mac address")
 	}
@@ -277,11 +361,11 @@ func (api RouterApi) BlockDevice(macAddress string) error {
 		Enabled:     true,
 		DefaultDeny: false,
 	}
-	if err := api.service.ToggleInternetAccessControl(cfg); err != nil {
+	if err := service.ToggleInternetAccessControl(cfg); err != nil {
 		return fmt.Errorf("error while toggling internet access '%v' ", err)
 	}
 
-	hosts, err := api.service.GetAccessControlHosts()
+	hosts, err := service.GetAccessControlHosts()
 	if err != nil {
 		return err
 	}
@@ -303,25 +387,30 @@ func (api RouterApi) BlockDevice(macAddress string) error {
 			return err
 		}
 
-		if _, err = api.service.AddAccessControlHost(host); err != nil {
+		if _, err = service.AddAccessControlHost(host); err != nil {
 			return fmt.Errorf("error while adding access control host '%v' ", err)
 		}
 	}
 
-	if _, err = api.service.AddAccessControlRule(host); err != nil {
+	if _, err = service.AddAccessControlRule(host); err != nil {
 		return fmt.Errorf("error while adding access control rule '%v' ", err)
 	}
-	fmt.Printf("device '%s' blocked\n", macAddress)
+	api.logger.Info("device blocked", "mac", macAddress)
 	return nil
 }
 
 func (api RouterApi) UnblockDevice(macAddress string) error {
+	return api.UnblockDeviceContext(context.Background(), macAddress)
+}
+
+func (api RouterApi) UnblockDeviceContext(ctx context.Context, macAddress string) error {
+	service := api.withContext(ctx)
 	if !tplinkapi.IsValidMacAddress(macAddress) {
 		return fmt.Errorf("invalid mac address")
 	}
 	macAddress = strings.ToUpper(macAddress)
 
-	hosts, err := api.service.GetAccessControlHosts()
+	hosts, err := service.GetAccessControlHosts()
 	if err != nil {
 		return err
 	}
@@ -341,7 +430,7 @@ func (api RouterApi) UnblockDevice(macAddress string) error {
 		return fmt.Errorf("host with mac '%s' not found", macAddress)
 	}
 
-	rules, err := api.service.GetAccessControlRules()
+	rules, err := service.GetAccessControlRules()
 	if err != nil {
 		return err
 	}
@@ -360,30 +449,51 @@ func (api RouterApi) UnblockDevice(macAddress string) error {
 		return fmt.Errorf("rule for host with ref '%s' not found", hostRef)
 	}
 
-	err = api.service.DeleteAccessControlRule(rule.Id)
+	err = service.DeleteAccessControlRule(rule.Id)
 	return err
 }
 
 func (api RouterApi) GetBlockedDevices() ([]storage.Device, error) {
-	deviceAddresses := make([]string, 0)
+	return api.GetBlockedDevicesContext(context.Background())
+}
+
+func (api RouterApi) GetBlockedDevicesContext(ctx context.Context) ([]storage.Device, error) {
 	devices := make([]storage.Device, 0)
+	deviceAddresses, err := api.getBlockedMacAddresses(ctx)
+	if err != nil || len(deviceAddresses) == 0 {
+		return devices, err
+	}
 
-	hosts, err := api.service.GetAccessControlHosts()
+	devices, err = api.store.DeviceStore.ReadManyByMacContext(ctx, deviceAddresses)
 	if err != nil {
 		return devices, err
 	}
 
+	return devices, nil
+}
+
+// getBlockedMacAddresses returns the mac addresses that have an access
+// control rule, whether or not they belong to a registered device.
+func (api RouterApi) getBlockedMacAddresses(ctx context.Context) ([]string, error) {
+	service := api.withContext(ctx)
+	deviceAddresses := make([]string, 0)
+
+	hosts, err := service.GetAccessControlHosts()
+	if err != nil {
+		return deviceAddresses, err
+	}
+
 	if len(hosts) == 0 {
-		return devices, nil
+		return deviceAddresses, nil
 	}
 
-	rules, err := api.service.GetAccessControlRules()
+	rules, err := service.GetAccessControlRules()
 	if err != nil {
-		return devices, err
+		return deviceAddresses, err
 	}
 
 	if len(rules) == 0 {
-		return devices, nil
+		return deviceAddresses, nil
 	}
 
 	refs := make(map[string]bool, 0)
@@ -401,57 +511,79 @@ func (api RouterApi) GetBlockedDevices() ([]storage.Device, error) {
 			}
 		}
 	}
-
-	devices, err = api.store.DeviceStore.ReadManyByMac(deviceAddresses)
-	if err != nil {
-		return devices, err
-	}
-
-	return devices, nil
+	return deviceAddresses, nil
 }
 
 func (api RouterApi) DeleteSlot(slotId int) error {
-	slot, err := api.store.BandwidthSlotStore.Read(slotId)
+	return api.DeleteSlotContext(context.Background(), slotId)
+}
+
+func (api RouterApi) DeleteSlotContext(ctx context.Context, slotId int) error {
+	slot, err := api.store.BandwidthSlotStore.ReadContext(ctx, slotId)
 	if err != nil {
 		return err
 	}
-	err = api.service.DeleteBwControlEntry(slot.RemoteId)
+	if err = api.removeSlot(ctx, slot); err != nil {
+		return err
+	}
+	if slot.DeviceId != 0 {
+		return api.releaseDeviceSlot(ctx, slot)
+	}
+	return nil
+}
+
+// removeSlot deletes the router entry, schedules and row of a slot.
+func (api RouterApi) removeSlot(ctx context.Context, slot storage.BandwidthSlot) error {
+	service := api.withContext(ctx)
+	if slot.Enabled {
+		err := service.DeleteBwControlEntry(slot.RemoteId)
+		if err != nil {
+			return err
+		}
+	}
+	err := api.store.ScheduleStore.DeleteBySlotIdContext(ctx, slot.Id)
 	if err != nil {
 		return err
 	}
-	err = api.store.BandwidthSlotStore.Delete(slotId)
+	err = api.store.BandwidthSlotStore.DeleteContext(ctx, slot.Id)
 	return err
 }
 
 func (api RouterApi) RegisterUser(name string) (*storage.User, error) {
+	return api.RegisterUserContext(context.Background(), name)
+}
+
+func (api RouterApi) RegisterUserContext(ctx context.Context, name string) (*storage.User, error) {
 	user := &storage.User{
 		Name: name,
 	}
-	err := api.store.UserStore.Create(user)
+	err := api.store.UserStore.CreateContext(ctx, user)
 	return user, err
 }
 
-func (api RouterApi) GetUserSlots(userId, pageSize, pageNumber int) ([]tplinkapi.BandwidthControlEntry, error) {
-	entries := make([]tplinkapi.BandwidthControlEntry, 0)
-	slots, err := api.store.BandwidthSlotStore.ReadManyByUserId(userId, pageSize, pageNumber)
-	if err != nil {
-		return entries, err
-	}
+func (api RouterApi) GetUserSlots(userId, pageSize, pageNumber int) ([]UserSlot, error) {
+	return api.GetUserSlotsContext(context.Background(), userId, pageSize, pageNumber)
+}
 
-	if len(slots) == 0 {
-		return entries, nil
+func (api RouterApi) GetUserSlotsContext(ctx context.Context, userId, pageSize, pageNumber int) ([]UserSlot, error) {
+	slots, err := api.store.BandwidthSlotStore.ReadManyByUserIdContext(ctx, userId, pageSize, pageNumber)
+	if err != nil {
+		return nil, err
 	}
+	return api.withEntries(ctx, slots)
+}
 
-	ids := make([]int, 0)
-	for _, slot := range slots {
-		ids = append(ids, slot.RemoteId)
-	}
+func (api RouterApi) AssignSlot(userId int, slot BwSlot, startIPAddress string, numDevices, maxUploadSpeed, maxDownloadSpeed int) error {
+	return api.AssignSlotContext(context.Background(), userId, slot, startIPAddress, numDevices, maxUploadSpeed, maxDownloadSpeed)
+}
 
-	entries, err = api.GetBwControlEntriesByList(ids)
-	return entries, err
+func (api RouterApi) AssignSlotContext(ctx context.Context, userId int, slot BwSlot, startIPAddress string, numDevices, maxUploadSpeed, maxDownloadSpeed int) error {
+	limits := SlotLimits{UpMin: 50, UpMax: maxUploadSpeed, DownMin: 50, DownMax: maxDownloadSpeed}
+	return api.assignSlot(ctx, userId, slot, startIPAddress, numDevices, limits, 0)
 }
 
-func (api RouterApi) AssignSlot(userId int, slot BwSlot, startIPAddress string, numDevices, maxUploadSpeed, maxDownloadSpeed int) error {
+func (api RouterApi) assignSlot(ctx context.Context, userId int, slot BwSlot, startIPAddress string, numDevices int, limits SlotLimits, planId int) error {
+	service := api.withContext(ctx)
 	var startIP string
 	if startIPAddress == "" {
 		startIP = slot.MinAddress
@@ -480,14 +612,24 @@ func (api RouterApi) AssignSlot(userId int, slot BwSlot, startIPAddress string,
 		startIP = startIPAddress
 	}
 
+	if numDevices < 1 {
+		return &SoftError{Message: "Number of devices must be at least 1"}
+	}
 	endIP, err := slot.GetMaxIP(startIP, numDevices)
 	if err != nil {
 		return err
 	}
+	slotRange, err := NewAddrRange(slot.MinAddress, slot.MaxAddress)
+	if err != nil {
+		return err
+	}
+	if end, _ := parseIPv4(endIP); !slotRange.Contains(end) {
+		return &SoftError{Message: fmt.Sprintf("Slot has no room for %d devices from %s", numDevices, startIP)}
+	}
 
 	endIpInt, _ := tplinkapi.Ip2Int(endIP)
 	minIpInt := endIpInt + 1
-	dhcpConfig, err := api.service.GetDhcpConfiguration()
+	dhcpConfig, err := service.GetDhcpConfiguration()
 	if err != nil {
 		return err
 	}
@@ -509,35 +651,50 @@ func (api RouterApi) AssignSlot(userId int, slot BwSlot, startIPAddress string,
 		Enabled: true,
 		StartIp: startIP,
 		EndIp:   endIP,
-		UpMin:   50,
-		UpMax:   maxUploadSpeed,
-		DownMin: 50,
-		DownMax: maxDownloadSpeed,
+		UpMin:   limits.UpMin,
+		UpMax:   limits.UpMax,
+		DownMin: limits.DownMin,
+		DownMax: limits.DownMax,
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return err
+	}
+	if err = validateLimits(entryLimits(entry), details); err != nil {
+		return err
 	}
-	id, err := api.service.AddBwControlEntry(entry)
+	id, err := service.AddBwControlEntry(entry)
 	if err != nil {
 		return err
 	}
 
 	if updateDhcp {
-		api.service.UpdateDhcpConfiguration(dhcpConfig)
+		service.UpdateDhcpConfiguration(dhcpConfig)
 	}
 	storageSlot := storage.BandwidthSlot{
 		UserId:   userId,
 		RemoteId: id,
+		PlanId:   planId,
 	}
-	err = api.store.BandwidthSlotStore.Create(&storageSlot)
+	setSlotEntry(&storageSlot, entry)
+	err = api.store.BandwidthSlotStore.CreateContext(ctx, &storageSlot)
 	return err
 }
 
 func (api RouterApi) DeregisterUser(userId int) error {
-	actions := []func(userId int) error{
-		api.store.BandwidthSlotStore.DeleteByUserId,
-		api.store.DeviceStore.DeleteByUserId,
-		api.store.UserStore.Delete,
+	return api.DeregisterUserContext(context.Background(), userId)
+}
+
+func (api RouterApi) DeregisterUserContext(ctx context.Context, userId int) error {
+	actions := []func(ctx context.Context, userId int) error{
+		api.store.ScheduleStore.DeleteByUserIdContext,
+		api.store.BandwidthSlotStore.DeleteByUserIdContext,
+		api.store.DeviceStore.DeleteByUserIdContext,
+		api.store.GroupStore.RemoveUserContext,
+		api.store.UserStore.DeleteContext,
 	}
 	for _, action := range actions {
-		err := action(userId)
+		err := action(ctx, userId)
 		if err != nil {
 			return err
 		}
@@ -546,44 +703,68 @@ func (api RouterApi) DeregisterUser(userId int) error {
 }
 
 func (api RouterApi) GetConnectedDevices() (tplinkapi.ClientStatistics, []storage.Device, error) {
+	return api.GetConnectedDevicesContext(context.Background())
+}
+
+func (api RouterApi) GetConnectedDevicesContext(ctx context.Context) (tplinkapi.ClientStatistics, []storage.Device, error) {
+	service := api.withContext(ctx)
 	var (
 		stats   tplinkapi.ClientStatistics
 		devices []storage.Device
 		err     error
 	)
-	stats, err = api.service.GetStatistics()
+	stats, err = service.GetStatistics()
 	if err != nil {
 		return stats, devices, err
 	}
 
-	macAddresses := make([]string, len(stats))
+	macAddresses := make([]string, 0, len(stats))
 	for _, stat := range stats {
 		macAddresses = append(macAddresses, stat.Mac)
 	}
 
-	devices, err = api.store.DeviceStore.ReadManyByMac(macAddresses)
+	devices, err = api.store.DeviceStore.ReadManyByMacContext(ctx, macAddresses)
 	return stats, devices, err
 }
 
 func (api RouterApi) GetIpMacBindings() ([]tplinkapi.ClientReservation, error) {
-	return api.service.GetIpMacBindings()
+	return api.GetIpMacBindingsContext(context.Background())
+}
+
+func (api RouterApi) GetIpMacBindingsContext(ctx context.Context) ([]tplinkapi.ClientReservation, error) {
+	service := api.withContext(ctx)
+	return service.GetIpMacBindings()
 }
 
 func (api RouterApi) GetAddressReservations() ([]tplinkapi.ClientReservation, error) {
-	return api.service.GetAddressReservations()
+	return api.GetAddressReservationsContext(context.Background())
+}
+
+func (api RouterApi) GetAddressReservationsContext(ctx context.Context) ([]tplinkapi.ClientReservation, error) {
+	service := api.withContext(ctx)
+	return service.GetAddressReservations()
 }
 
 func (api RouterApi) RegisterDevice(mac, alias string, slotId, userId int) error {
-	slot, err := api.store.BandwidthSlotStore.Read(slotId)
+	return api.RegisterDeviceContext(context.Background(), mac, alias, slotId, userId)
+}
+
+func (api RouterApi) RegisterDeviceContext(ctx context.Context, mac, alias string, slotId, userId int) error {
+	service := api.withContext(ctx)
+	_, entry, err := api.getSlotEntry(ctx, slotId)
 	if err != nil {
 		return err
 	}
 
-	if _, err = api.store.UserStore.Read(userId); err != nil {
+	if _, err = api.store.UserStore.ReadContext(ctx, userId); err != nil {
 		return err
 	}
 
-	ipAddress, err := api.GetUnusedIPAddress(slot.RemoteId)
+	slotRange, err := NewAddrRange(entry.StartIp, entry.EndIp)
+	if err != nil {
+		return err
+	}
+	ipAddress, err := api.getUnusedIPAddress(ctx, slotRange)
 	if err != nil {
 		return err
 	}
@@ -596,11 +777,11 @@ func (api RouterApi) RegisterDevice(mac, alias string, slotId, userId int) error
 	// 	return fmt.Errorf("multicast addresses not allowed")
 	// }
 
-	if err = api.service.MakeIpAddressReservation(client); err != nil {
+	if err = service.MakeIpAddressReservation(client); err != nil {
 		return err
 	}
 
-	existingDevices, err := api.store.DeviceStore.ReadManyByMac([]string{client.Mac})
+	existingDevices, err := api.store.DeviceStore.ReadManyByMacContext(ctx, []string{client.Mac})
 	if err != nil {
 		return err
 	}
@@ -612,7 +793,7 @@ func (api RouterApi) RegisterDevice(mac, alias string, slotId, userId int) error
 			Alias:  alias,
 		}
 
-		err = api.store.DeviceStore.Create(&device)
+		err = api.store.DeviceStore.CreateContext(ctx, &device)
 		if err != nil {
 			return err
 		}
@@ -621,19 +802,228 @@ func (api RouterApi) RegisterDevice(mac, alias string, slotId, userId int) error
 }
 
 func (api RouterApi) DeregisterDevice(deviceId int) error {
-	device, err := api.store.DeviceStore.Read(deviceId)
+	return api.DeregisterDeviceContext(context.Background(), deviceId)
+}
+
+func (api RouterApi) DeregisterDeviceContext(ctx context.Context, deviceId int) error {
+	service := api.withContext(ctx)
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
 	if err != nil {
 		return err
 	}
 
-	err = api.service.DeleteIpAddressReservation(device.Mac)
+	if slot, err := api.store.BandwidthSlotStore.ReadByDeviceIdContext(ctx, deviceId); err == nil {
+		if err = api.removeSlot(ctx, slot); err != nil {
+			return err
+		}
+	}
+
+	err = service.DeleteIpAddressReservation(device.Mac)
 	if err != nil {
 		return err
 	}
 
-	err = api.store.DeviceStore.Delete(deviceId)
+	err = api.store.DeviceStore.DeleteContext(ctx, deviceId)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+const pageSizeAll = 100
+
+func (api RouterApi) getAllUserSlots(ctx context.Context, userId int) ([]storage.BandwidthSlot, error) {
+	slots := make([]storage.BandwidthSlot, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.BandwidthSlotStore.ReadManyByUserIdContext(ctx, userId, pageSizeAll, pageNumber)
+		if err != nil {
+			return slots, err
+		}
+		slots = append(slots, page...)
+		if len(page) < pageSizeAll {
+			return slots, nil
+		}
+	}
+}
+
+func (api RouterApi) getAllUserDevices(ctx context.Context, userId int) ([]storage.Device, error) {
+	devices := make([]storage.Device, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.DeviceStore.ReadManyByUserIdContext(ctx, userId, pageSizeAll, pageNumber)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, page...)
+		if len(page) < pageSizeAll {
+			return devices, nil
+		}
+	}
+}
+
+func (api RouterApi) getAllUsers(ctx context.Context) ([]storage.User, error) {
+	users := make([]storage.User, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.UserStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, page...)
+		if len(page) < pageSizeAll {
+			return users, nil
+		}
+	}
+}
+
+func (api RouterApi) getAllSlots(ctx context.Context) ([]storage.BandwidthSlot, error) {
+	slots := make([]storage.BandwidthSlot, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.BandwidthSlotStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return slots, err
+		}
+		slots = append(slots, page...)
+		if len(page) < pageSizeAll {
+			return slots, nil
+		}
+	}
+}
+
+type RemovalPreview struct {
+	RouterEntries []string
+	DbRows        []string
+}
+
+func describeBwEntry(entry tplinkapi.BandwidthControlEntry) string {
+	return fmt.Sprintf(
+		"bandwidth entry #%d %s - %s Up:%d/%d Down:%d/%d",
+		entry.Id, entry.StartIp, entry.EndIp, entry.UpMin, entry.UpMax, entry.DownMin, entry.DownMax,
+	)
+}
+
+func describeSlotRow(slot storage.BandwidthSlot) string {
+	return fmt.Sprintf("bw_slots #%d (user %d, remote entry %d)", slot.Id, slot.UserId, slot.RemoteId)
+}
+
+func describeScheduleRow(schedule storage.SlotSchedule) string {
+	return fmt.Sprintf("slot_schedules #%d (slot %d, %s - %s)", schedule.Id, schedule.SlotId, schedule.Start, schedule.End)
+}
+
+func describeDeviceRow(device storage.Device) string {
+	return fmt.Sprintf("devices #%d %s '%s'", device.Id, device.Mac, device.Alias)
+}
+
+func describeDeviceTagRow(device storage.Device, tag string) string {
+	return fmt.Sprintf("device_tags (device %d, '%s')", device.Id, tag)
+}
+
+func describeGroupMemberRow(group storage.Group, userId int) string {
+	return fmt.Sprintf("user_group_members (group %d '%s', user %d)", group.Id, group.Name, userId)
+}
+
+func (api RouterApi) PreviewDeleteSlot(slotId int) (RemovalPreview, error) {
+	return api.PreviewDeleteSlotContext(context.Background(), slotId)
+}
+
+func (api RouterApi) PreviewDeleteSlotContext(ctx context.Context, slotId int) (RemovalPreview, error) {
+	var preview RemovalPreview
+	slot, entry, err := api.getSlotEntry(ctx, slotId)
+	if err != nil {
+		return preview, err
+	}
+	if slot.Enabled {
+		preview.RouterEntries = append(preview.RouterEntries, describeBwEntry(entry))
+	}
+	preview.DbRows = append(preview.DbRows, describeSlotRow(slot))
+	schedules, err := api.store.ScheduleStore.ReadManyBySlotIdContext(ctx, slotId)
+	if err != nil {
+		return preview, err
+	}
+	for _, schedule := range schedules {
+		preview.DbRows = append(preview.DbRows, describeScheduleRow(schedule))
+	}
+	return preview, nil
+}
+
+func (api RouterApi) PreviewDeregisterUser(userId int) (RemovalPreview, error) {
+	return api.PreviewDeregisterUserContext(context.Background(), userId)
+}
+
+func (api RouterApi) PreviewDeregisterUserContext(ctx context.Context, userId int) (RemovalPreview, error) {
+	var preview RemovalPreview
+	user, err := api.store.UserStore.ReadContext(ctx, userId)
+	if err != nil {
+		return preview, err
+	}
+	slots, err := api.getAllUserSlots(ctx, userId)
+	if err != nil {
+		return preview, err
+	}
+	for _, slot := range slots {
+		preview.DbRows = append(preview.DbRows, describeSlotRow(slot))
+		schedules, err := api.store.ScheduleStore.ReadManyBySlotIdContext(ctx, slot.Id)
+		if err != nil {
+			return preview, err
+		}
+		for _, schedule := range schedules {
+			preview.DbRows = append(preview.DbRows, describeScheduleRow(schedule))
+		}
+	}
+	devices, err := api.getAllUserDevices(ctx, userId)
+	if err != nil {
+		return preview, err
+	}
+	for _, device := range devices {
+		for _, tag := range device.Tags {
+			preview.DbRows = append(preview.DbRows, describeDeviceTagRow(device, tag))
+		}
+		preview.DbRows = append(preview.DbRows, describeDeviceRow(device))
+	}
+	groups, err := api.store.GroupStore.ReadManyByUserIdContext(ctx, userId)
+	if err != nil {
+		return preview, err
+	}
+	for _, group := range groups {
+		preview.DbRows = append(preview.DbRows, describeGroupMemberRow(group, userId))
+	}
+	preview.DbRows = append(preview.DbRows, fmt.Sprintf("users #%d '%s'", user.Id, user.Name))
+	return preview, nil
+}
+
+func (api RouterApi) PreviewDeregisterDevice(deviceId int) (RemovalPreview, error) {
+	return api.PreviewDeregisterDeviceContext(context.Background(), deviceId)
+}
+
+func (api RouterApi) PreviewDeregisterDeviceContext(ctx context.Context, deviceId int) (RemovalPreview, error) {
+	service := api.withContext(ctx)
+	var preview RemovalPreview
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return preview, err
+	}
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return preview, err
+	}
+	for _, resv := range reservations {
+		if strings.EqualFold(resv.Mac, device.Mac) {
+			preview.RouterEntries = append(
+				preview.RouterEntries,
+				fmt.Sprintf("dhcp reservation %s -> %s", resv.Mac, resv.IP),
+				fmt.Sprintf("ip-mac binding %s -> %s", resv.Mac, resv.IP),
+			)
+		}
+	}
+	if slot, err := api.store.BandwidthSlotStore.ReadByDeviceIdContext(ctx, deviceId); err == nil {
+		slotPreview, err := api.PreviewDeleteSlotContext(ctx, slot.Id)
+		if err != nil {
+			return preview, err
+		}
+		preview.RouterEntries = append(preview.RouterEntries, slotPreview.RouterEntries...)
+		preview.DbRows = append(preview.DbRows, slotPreview.DbRows...)
+	}
+	for _, tag := range device.Tags {
+		preview.DbRows = append(preview.DbRows, describeDeviceTagRow(device, tag))
+	}
+	preview.DbRows = append(preview.DbRows, describeDeviceRow(device))
+	return preview, nil
+}
@@ -0,0 +1,346 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+// fakeRouter keeps the router state used to place bandwidth slots.
+type fakeRouter struct {
+	RouterService
+	routerIP string
+	lan      tplinkapi.LanConfig
+	dhcp     tplinkapi.DhcpConfiguration
+	entries  []tplinkapi.BandwidthControlEntry
+}
+
+func (f *fakeRouter) GetRouterInfo() (tplinkapi.RouterInfo, error) {
+	var info tplinkapi.RouterInfo
+	info.IP = f.routerIP
+	return info, nil
+}
+
+func (f *fakeRouter) GetLanConfig() (tplinkapi.LanConfig, error) {
+	return f.lan, nil
+}
+
+func (f *fakeRouter) GetBandwidthControlDetails() (tplinkapi.BandwidthControlDetail, error) {
+	return tplinkapi.BandwidthControlDetail{Entries: f.entries}, nil
+}
+
+func (f *fakeRouter) AddBwControlEntry(entry tplinkapi.BandwidthControlEntry) (int, error) {
+	entry.Id = len(f.entries) + 1
+	f.entries = append(f.entries, entry)
+	return entry.Id, nil
+}
+
+func (f *fakeRouter) GetDhcpConfiguration() (tplinkapi.DhcpConfiguration, error) {
+	return f.dhcp, nil
+}
+
+func (f *fakeRouter) UpdateDhcpConfiguration(cfg tplinkapi.DhcpConfiguration) error {
+	f.dhcp = cfg
+	return nil
+}
+
+func newTestApi(t *testing.T, router RouterService) *RouterApi {
+	t.Helper()
+	db, err := storage.ConnectDatabase(storage.DbConfig{Init: true, URI: filepath.Join(t.TempDir(), "routerman.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &RouterApi{
+		name:    "default",
+		service: router,
+		store:   storage.NewStore(db, "default"),
+		logger:  discardLogger(),
+	}
+}
+
+func entry(start, end string, enabled bool) tplinkapi.BandwidthControlEntry {
+	return tplinkapi.BandwidthControlEntry{StartIp: start, EndIp: end, Enabled: enabled}
+}
+
+func TestGetAvailableBandwidthSlots(t *testing.T) {
+	tests := []struct {
+		name          string
+		router        fakeRouter
+		useDhcpBounds bool
+		want          []string
+	}{
+		{
+			name: "router at the bottom of the subnet",
+			router: fakeRouter{
+				routerIP: "192.168.0.1",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+			},
+			want: []string{"192.168.0.2", "192.168.0.254"},
+		},
+		{
+			name: "router in the middle of the subnet",
+			router: fakeRouter{
+				routerIP: "192.168.0.50",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+			},
+			want: []string{"192.168.0.1", "192.168.0.49", "192.168.0.51", "192.168.0.254"},
+		},
+		{
+			name: "router at the top of the subnet",
+			router: fakeRouter{
+				routerIP: "192.168.0.254",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+			},
+			want: []string{"192.168.0.1", "192.168.0.253"},
+		},
+		{
+			name: "/16 subnet",
+			router: fakeRouter{
+				routerIP: "172.16.0.1",
+				lan:      tplinkapi.LanConfig{MinAddress: "172.16.1.0", MaxAddress: "172.16.1.255", SubnetMask: "255.255.0.0"},
+				entries:  []tplinkapi.BandwidthControlEntry{entry("172.16.0.10", "172.16.0.19", true)},
+			},
+			want: []string{"172.16.0.2", "172.16.0.9", "172.16.0.20", "172.16.255.254"},
+		},
+		{
+			name: "entry straddling the router address",
+			router: fakeRouter{
+				routerIP: "192.168.0.50",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+				entries:  []tplinkapi.BandwidthControlEntry{entry("192.168.0.45", "192.168.0.55", true)},
+			},
+			want: []string{"192.168.0.1", "192.168.0.44", "192.168.0.56", "192.168.0.254"},
+		},
+		{
+			name: "disabled entries are free",
+			router: fakeRouter{
+				routerIP: "192.168.0.1",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+				entries: []tplinkapi.BandwidthControlEntry{
+					entry("192.168.0.10", "192.168.0.19", false),
+					entry("192.168.0.20", "192.168.0.29", true),
+				},
+			},
+			want: []string{"192.168.0.2", "192.168.0.19", "192.168.0.30", "192.168.0.254"},
+		},
+		{
+			name: "dhcp bounds",
+			router: fakeRouter{
+				routerIP: "192.168.0.1",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+				entries: []tplinkapi.BandwidthControlEntry{
+					entry("192.168.0.10", "192.168.0.19", true),
+					entry("192.168.0.150", "192.168.0.159", true),
+				},
+			},
+			useDhcpBounds: true,
+			want:          []string{"192.168.0.100", "192.168.0.149", "192.168.0.160", "192.168.0.199"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := tt.router
+			api := newTestApi(t, &router)
+			slots, err := api.GetAvailableBandwidthSlots(tt.useDhcpBounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(slots)*2)
+			for _, slot := range slots {
+				got = append(got, slot.MinAddress, slot.MaxAddress)
+				if slot.SubnetMask != router.lan.SubnetMask {
+					t.Errorf("slot %s - %s has subnet mask %s", slot.MinAddress, slot.MaxAddress, slot.SubnetMask)
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("GetAvailableBandwidthSlots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBwSlotGetMaxIP(t *testing.T) {
+	tests := []struct {
+		start   string
+		n       int
+		want    string
+		wantErr bool
+	}{
+		{start: "192.168.0.10", n: 1, want: "192.168.0.10"},
+		{start: "192.168.0.10", n: 4, want: "192.168.0.13"},
+		{start: "192.168.0.250", n: 10, want: "192.168.1.3"},
+		{start: "not an address", n: 1, wantErr: true},
+	}
+	var slot BwSlot
+	for _, tt := range tests {
+		got, err := slot.GetMaxIP(tt.start, tt.n)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("GetMaxIP(%s, %d) = %s, want an error", tt.start, tt.n, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("GetMaxIP(%s, %d) = %s, %v, want %s", tt.start, tt.n, got, err, tt.want)
+		}
+	}
+}
+
+func TestAllocateBandwidthSlot(t *testing.T) {
+	// Free addresses: .2-.9 (8), .20-.39 (20) and .45-.254 (210).
+	router := fakeRouter{
+		routerIP: "192.168.0.1",
+		lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+		entries: []tplinkapi.BandwidthControlEntry{
+			entry("192.168.0.10", "192.168.0.19", true),
+			entry("192.168.0.40", "192.168.0.44", true),
+		},
+	}
+	tests := []struct {
+		name     string
+		n        int
+		strategy AllocationStrategy
+		want     []string
+		wantSoft bool
+	}{
+		{name: "first fit", n: 4, strategy: FirstFit, want: []string{"192.168.0.2", "192.168.0.5"}},
+		{name: "best fit uses the small gap", n: 8, strategy: BestFit, want: []string{"192.168.0.2", "192.168.0.9"}},
+		{name: "first fit skips a gap that is too small", n: 9, strategy: FirstFit, want: []string{"192.168.0.20", "192.168.0.28"}},
+		{name: "best fit keeps the large range", n: 15, strategy: BestFit, want: []string{"192.168.0.20", "192.168.0.34"}},
+		{name: "first fit takes the large range", n: 30, strategy: FirstFit, want: []string{"192.168.0.45", "192.168.0.74"}},
+		{name: "no room", n: 211, strategy: BestFit, wantSoft: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestApi(t, &router)
+			slot, err := api.AllocateBandwidthSlot(tt.n, tt.strategy, false)
+			if tt.wantSoft {
+				var softErr *SoftError
+				if !errors.As(err, &softErr) {
+					t.Fatalf("AllocateBandwidthSlot(%d) error = %v, want a SoftError", tt.n, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := []string{slot.MinAddress, slot.MaxAddress}; !equalStrings(got, tt.want) {
+				t.Errorf("AllocateBandwidthSlot(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+			if capacity, _ := slot.GetCapacity(); capacity != tt.n {
+				t.Errorf("AllocateBandwidthSlot(%d) has capacity %d", tt.n, capacity)
+			}
+		})
+	}
+}
+
+func TestAssignSlotPlacement(t *testing.T) {
+	tests := []struct {
+		name     string
+		startIP  string
+		n        int
+		want     []string
+		wantSoft bool
+	}{
+		{name: "from the start of the slot", n: 4, want: []string{"192.168.0.20", "192.168.0.23"}},
+		{name: "whole slot", n: 10, want: []string{"192.168.0.20", "192.168.0.29"}},
+		{name: "from a given address", startIP: "192.168.0.25", n: 5, want: []string{"192.168.0.25", "192.168.0.29"}},
+		{name: "single address", startIP: "192.168.0.29", n: 1, want: []string{"192.168.0.29", "192.168.0.29"}},
+		{name: "past the end of the slot", startIP: "192.168.0.25", n: 6, wantSoft: true},
+		{name: "more devices than addresses", n: 11, wantSoft: true},
+		{name: "start below the slot", startIP: "192.168.0.19", n: 1, wantSoft: true},
+		{name: "start above the slot", startIP: "192.168.0.30", n: 1, wantSoft: true},
+		{name: "no devices", n: 0, wantSoft: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &fakeRouter{
+				routerIP: "192.168.0.1",
+				lan:      tplinkapi.LanConfig{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199", SubnetMask: "255.255.255.0"},
+				dhcp:     tplinkapi.DhcpConfiguration{MinAddress: "192.168.0.100", MaxAddress: "192.168.0.199"},
+			}
+			api := newTestApi(t, router)
+			user, err := api.RegisterUser("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			slot, err := newBwSlot(mustRange(t, "192.168.0.20", "192.168.0.29"), "255.255.255.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = api.AssignSlot(user.Id, slot, tt.startIP, tt.n, 1000, 1000)
+			if tt.wantSoft {
+				var softErr *SoftError
+				if !errors.As(err, &softErr) {
+					t.Fatalf("AssignSlot error = %v, want a SoftError", err)
+				}
+				if len(router.entries) != 0 {
+					t.Errorf("AssignSlot added entries %v", router.entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(router.entries) != 1 {
+				t.Fatalf("router has entries %v, want one", router.entries)
+			}
+			if got := []string{router.entries[0].StartIp, router.entries[0].EndIp}; !equalStrings(got, tt.want) {
+				t.Errorf("entry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewDeregisterUser(t *testing.T) {
+	api := newTestApi(t, &fakeRouter{})
+	user, err := api.RegisterUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := storage.Device{UserId: user.Id, Alias: "phone", Mac: "AA:BB:CC:DD:EE:01", Tags: []string{"kids", "mobile"}}
+	if err = api.store.DeviceStore.Create(&device); err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := api.CreateGroup("family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api.AddGroupMember(group.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := api.PreviewDeregisterUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fmt.Sprintf("device_tags (device %d, 'kids')", device.Id),
+		fmt.Sprintf("device_tags (device %d, 'mobile')", device.Id),
+		fmt.Sprintf("devices #%d AA:BB:CC:DD:EE:01 'phone'", device.Id),
+		fmt.Sprintf("user_group_members (group %d 'family', user %d)", group.Id, user.Id),
+		fmt.Sprintf("users #%d 'alice'", user.Id),
+	}
+	if !equalStrings(preview.DbRows, want) {
+		t.Errorf("DbRows = %v, want %v", preview.DbRows, want)
+	}
+}
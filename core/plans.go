@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+func planLimits(plan storage.Plan) SlotLimits {
+	return SlotLimits{UpMin: plan.UpMin, UpMax: plan.UpMax, DownMin: plan.DownMin, DownMax: plan.DownMax}
+}
+
+func (api RouterApi) GetPlans() ([]storage.Plan, error) {
+	return api.GetPlansContext(context.Background())
+}
+
+func (api RouterApi) GetPlansContext(ctx context.Context) ([]storage.Plan, error) {
+	plans := make([]storage.Plan, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.PlanStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return plans, err
+		}
+		plans = append(plans, page...)
+		if len(page) < pageSizeAll {
+			return plans, nil
+		}
+	}
+}
+
+func (api RouterApi) GetPlan(name string) (storage.Plan, error) {
+	return api.GetPlanContext(context.Background(), name)
+}
+
+func (api RouterApi) GetPlanContext(ctx context.Context, name string) (storage.Plan, error) {
+	return api.store.PlanStore.ReadByNameContext(ctx, name)
+}
+
+// validatePlan checks a plan against the router totals and makes sure its
+// name is not used by another plan.
+func (api RouterApi) validatePlan(ctx context.Context, plan storage.Plan) error {
+	service := api.withContext(ctx)
+	if strings.TrimSpace(plan.Name) == "" {
+		return &SoftError{Message: "Plan name is required"}
+	}
+	if plan.Devices < 1 {
+		return &SoftError{Message: "Number of devices must be at least 1"}
+	}
+	if other, err := api.store.PlanStore.ReadByNameContext(ctx, plan.Name); err == nil && other.Id != plan.Id {
+		return &SoftError{Message: fmt.Sprintf("Plan '%s' already exists", plan.Name)}
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return err
+	}
+	return validateLimits(planLimits(plan), details)
+}
+
+func (api RouterApi) CreatePlan(plan storage.Plan) (storage.Plan, error) {
+	return api.CreatePlanContext(context.Background(), plan)
+}
+
+func (api RouterApi) CreatePlanContext(ctx context.Context, plan storage.Plan) (storage.Plan, error) {
+	plan.Id = 0
+	if err := api.validatePlan(ctx, plan); err != nil {
+		return plan, err
+	}
+	err := api.store.PlanStore.CreateContext(ctx, &plan)
+	return plan, err
+}
+
+func (api RouterApi) UpdatePlan(plan storage.Plan, propagate bool) (int, error) {
+	return api.UpdatePlanContext(context.Background(), plan, propagate)
+}
+
+// UpdatePlanContext stores a changed plan. With propagate the new limits are
+// also applied to every slot created from the plan, and the number of slots
+// changed is returned. Existing slots keep their size either way.
+func (api RouterApi) UpdatePlanContext(ctx context.Context, plan storage.Plan, propagate bool) (int, error) {
+	if _, err := api.store.PlanStore.ReadContext(ctx, plan.Id); err != nil {
+		return 0, err
+	}
+	if err := api.validatePlan(ctx, plan); err != nil {
+		return 0, err
+	}
+	if err := api.store.PlanStore.UpdateContext(ctx, plan); err != nil {
+		return 0, err
+	}
+	if !propagate {
+		return 0, nil
+	}
+
+	slots, err := api.store.BandwidthSlotStore.ReadManyByPlanIdContext(ctx, plan.Id)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, slot := range slots {
+		if err = api.UpdateSlotContext(ctx, slot.Id, planLimits(plan), slot.Enabled); err != nil {
+			return updated, fmt.Errorf("slot %d: %w", slot.Id, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (api RouterApi) DeletePlan(planId int) error {
+	return api.DeletePlanContext(context.Background(), planId)
+}
+
+// DeletePlanContext removes a plan. Slots created from it keep their limits.
+func (api RouterApi) DeletePlanContext(ctx context.Context, planId int) error {
+	if err := api.store.BandwidthSlotStore.ClearPlanContext(ctx, planId); err != nil {
+		return err
+	}
+	return api.store.PlanStore.DeleteContext(ctx, planId)
+}
+
+func (api RouterApi) AssignPlanSlot(userId int, slot BwSlot, startIPAddress string, numDevices int, planName string) error {
+	return api.AssignPlanSlotContext(context.Background(), userId, slot, startIPAddress, numDevices, planName)
+}
+
+// AssignPlanSlotContext assigns a slot with the limits of a plan. When
+// numDevices is 0 the plan's device count is used.
+func (api RouterApi) AssignPlanSlotContext(ctx context.Context, userId int, slot BwSlot, startIPAddress string, numDevices int, planName string) error {
+	plan, err := api.GetPlanContext(ctx, planName)
+	if err != nil {
+		return err
+	}
+	if numDevices == 0 {
+		numDevices = plan.Devices
+	}
+	return api.assignSlot(ctx, userId, slot, startIPAddress, numDevices, planLimits(plan), plan.Id)
+}
+
+func (api RouterApi) SetSlotPlan(slotId int, planName string) error {
+	return api.SetSlotPlanContext(context.Background(), slotId, planName)
+}
+
+// SetSlotPlanContext applies the limits of a plan to an existing slot and
+// links the slot to the plan so later plan changes can reach it.
+func (api RouterApi) SetSlotPlanContext(ctx context.Context, slotId int, planName string) error {
+	plan, err := api.GetPlanContext(ctx, planName)
+	if err != nil {
+		return err
+	}
+	slot, err := api.store.BandwidthSlotStore.ReadContext(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	if err = api.UpdateSlotContext(ctx, slotId, planLimits(plan), slot.Enabled); err != nil {
+		return err
+	}
+	slot, err = api.store.BandwidthSlotStore.ReadContext(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	slot.PlanId = plan.Id
+	return api.store.BandwidthSlotStore.UpdateContext(ctx, slot)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrRouterUnreachable = errors.New("router unreachable")
+
+type ResiliencePolicy struct {
+	Timeout          time.Duration
+	Retries          int
+	InitialBackoff   time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+var DefaultResiliencePolicy = ResiliencePolicy{
+	Timeout:          8 * time.Second,
+	Retries:          3,
+	InitialBackoff:   500 * time.Millisecond,
+	MaxBackoff:       5 * time.Second,
+	FailureThreshold: 3,
+	OpenDuration:     30 * time.Second,
+}
+
+// Resilience bounds every router call with a timeout, retries reads that
+// failed to reach the router and stops calling the router for a while once
+// it keeps failing. Writes are never retried since the router may have
+// applied them before the connection dropped.
+type Resilience struct {
+	mu        sync.Mutex
+	policy    ResiliencePolicy
+	failures  int
+	openUntil time.Time
+	logger    *slog.Logger
+}
+
+func NewResilience(policy ResiliencePolicy) *Resilience {
+	return &Resilience{policy: policy, logger: discardLogger()}
+}
+
+func (r *Resilience) SetPolicy(policy ResiliencePolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+func isTransient(err error) bool {
+	var (
+		urlErr *url.Error
+		netErr net.Error
+	)
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+func (r *Resilience) Intercept(call Call, next func() (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	policy := r.policy
+	openUntil := r.openUntil
+	r.mu.Unlock()
+
+	if time.Now().Before(openUntil) {
+		return nil, fmt.Errorf("%w: not retrying for %s after repeated failures", ErrRouterUnreachable, time.Until(openUntil).Round(time.Second))
+	}
+
+	attempts := 1
+	if !call.Write {
+		attempts += policy.Retries
+	}
+
+	var (
+		result  interface{}
+		err     error
+		backoff = policy.InitialBackoff
+	)
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-call.Context.Done():
+				return nil, call.Context.Err()
+			}
+			backoff *= 2
+			if backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+
+		result, err = runWithTimeout(call.Context, policy.Timeout, next)
+		if ctxErr := call.Context.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if !isTransient(err) {
+			r.recordSuccess()
+			return result, err
+		}
+		r.logger.Warn("router call failed", "call", call.Name, "attempt", attempt+1, "error", err)
+	}
+
+	r.recordFailure(policy)
+	if attempts == 1 {
+		return nil, fmt.Errorf("%w: %s did not complete, check the router before retrying", ErrRouterUnreachable, call.Name)
+	}
+	return nil, fmt.Errorf("%w: %s failed after %d attempts", ErrRouterUnreachable, call.Name, attempts)
+}
+
+// runWithTimeout stops waiting for next after timeout or once ctx is done.
+// tplinkapi gives no way to cancel the HTTP request, so it runs on until its
+// own client timeout and its result is dropped.
+func runWithTimeout(ctx context.Context, timeout time.Duration, next func() (interface{}, error)) (interface{}, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return next()
+	}
+	return runUntilDone(ctx, next)
+}
+
+func (r *Resilience) recordSuccess() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = 0
+}
+
+func (r *Resilience) recordFailure(policy ResiliencePolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures++
+	if policy.FailureThreshold > 0 && r.failures >= policy.FailureThreshold {
+		r.openUntil = time.Now().Add(policy.OpenDuration)
+		r.failures = 0
+		r.logger.Error("router unreachable, pausing router calls", "duration", policy.OpenDuration)
+	}
+}
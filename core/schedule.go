@@ -0,0 +1,268 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+// weekdays are indexed by time.Weekday.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseClock returns the minutes since midnight of a "HH:MM" time.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, &SoftError{Message: fmt.Sprintf("invalid time '%s', expected HH:MM", value)}
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseDays reads a comma separated list of weekdays. An empty list means
+// every day and gives an empty set.
+func parseDays(value string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		found := false
+		for day, weekday := range weekdays {
+			if weekday == name {
+				days[time.Weekday(day)] = true
+				found = true
+			}
+		}
+		if !found {
+			return days, &SoftError{Message: fmt.Sprintf("invalid day '%s', expected one of %s", name, strings.Join(weekdays, ","))}
+		}
+	}
+	return days, nil
+}
+
+// formatDays writes days starting from Monday, the way they are stored.
+func formatDays(days map[time.Weekday]bool) string {
+	names := make([]string, 0, len(days))
+	for i := 1; i <= len(weekdays); i++ {
+		day := time.Weekday(i % len(weekdays))
+		if days[day] {
+			names = append(names, weekdays[day])
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func scheduleLimits(schedule storage.SlotSchedule) SlotLimits {
+	return SlotLimits{UpMin: schedule.UpMin, UpMax: schedule.UpMax, DownMin: schedule.DownMin, DownMax: schedule.DownMax}
+}
+
+// scheduleActive reports whether t falls inside the schedule. A window that
+// ends before it starts runs past midnight and belongs to the day it starts.
+func scheduleActive(schedule storage.SlotSchedule, t time.Time) bool {
+	start, err := parseClock(schedule.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(schedule.End)
+	if err != nil {
+		return false
+	}
+	days, err := parseDays(schedule.Days)
+	if err != nil {
+		return false
+	}
+	onDay := func(day time.Weekday) bool {
+		return len(days) == 0 || days[day]
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return now >= start && now < end && onDay(t.Weekday())
+	}
+	if now >= start {
+		return onDay(t.Weekday())
+	}
+	return now < end && onDay((t.Weekday()+6)%7)
+}
+
+func (api RouterApi) GetSchedules() ([]storage.SlotSchedule, error) {
+	return api.GetSchedulesContext(context.Background())
+}
+
+func (api RouterApi) GetSchedulesContext(ctx context.Context) ([]storage.SlotSchedule, error) {
+	schedules := make([]storage.SlotSchedule, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.ScheduleStore.ReadManyContext(ctx, pageSizeAll, pageNumber)
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, page...)
+		if len(page) < pageSizeAll {
+			return schedules, nil
+		}
+	}
+}
+
+func (api RouterApi) GetSlotSchedules(slotId int) ([]storage.SlotSchedule, error) {
+	return api.GetSlotSchedulesContext(context.Background(), slotId)
+}
+
+func (api RouterApi) GetSlotSchedulesContext(ctx context.Context, slotId int) ([]storage.SlotSchedule, error) {
+	return api.store.ScheduleStore.ReadManyBySlotIdContext(ctx, slotId)
+}
+
+func (api RouterApi) AddSlotSchedule(schedule storage.SlotSchedule) (storage.SlotSchedule, error) {
+	return api.AddSlotScheduleContext(context.Background(), schedule)
+}
+
+// AddSlotScheduleContext stores a schedule for a slot. It takes effect the
+// next time schedules are applied.
+func (api RouterApi) AddSlotScheduleContext(ctx context.Context, schedule storage.SlotSchedule) (storage.SlotSchedule, error) {
+	service := api.withContext(ctx)
+	slot, err := api.store.BandwidthSlotStore.ReadContext(ctx, schedule.SlotId)
+	if err != nil {
+		return schedule, err
+	}
+	if slot.StartIp == "" {
+		return schedule, &SoftError{Message: fmt.Sprintf("Slot %d has no stored limits, edit its limits first", slot.Id)}
+	}
+	start, err := parseClock(schedule.Start)
+	if err != nil {
+		return schedule, err
+	}
+	end, err := parseClock(schedule.End)
+	if err != nil {
+		return schedule, err
+	}
+	if start == end {
+		return schedule, &SoftError{Message: "Schedule start and end times must differ"}
+	}
+	days, err := parseDays(schedule.Days)
+	if err != nil {
+		return schedule, err
+	}
+	schedule.Days = formatDays(days)
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return schedule, err
+	}
+	if err = validateLimits(scheduleLimits(schedule), details); err != nil {
+		return schedule, err
+	}
+	err = api.store.ScheduleStore.CreateContext(ctx, &schedule)
+	return schedule, err
+}
+
+func (api RouterApi) DeleteSlotSchedule(scheduleId int) error {
+	return api.DeleteSlotScheduleContext(context.Background(), scheduleId)
+}
+
+// DeleteSlotScheduleContext removes a schedule. If it is active the slot
+// returns to its own limits the next time schedules are applied.
+func (api RouterApi) DeleteSlotScheduleContext(ctx context.Context, scheduleId int) error {
+	if _, err := api.store.ScheduleStore.ReadContext(ctx, scheduleId); err != nil {
+		return err
+	}
+	return api.store.ScheduleStore.DeleteContext(ctx, scheduleId)
+}
+
+func (api RouterApi) ApplySchedules(now time.Time) (int, error) {
+	return api.ApplySchedulesContext(context.Background(), now)
+}
+
+// ApplySchedulesContext sets the router entry of every enabled slot to the
+// limits of its first schedule active at now, or to the slot's own limits
+// when none is. The stored slot limits are never changed, so the result
+// depends only on the database and the time. It returns the number of
+// entries changed.
+func (api RouterApi) ApplySchedulesContext(ctx context.Context, now time.Time) (int, error) {
+	service := api.withContext(ctx)
+	slots, err := api.getAllSlots(ctx)
+	if err != nil {
+		return 0, err
+	}
+	schedules, err := api.GetSchedulesContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	slotSchedules := make(map[int][]storage.SlotSchedule)
+	for _, schedule := range schedules {
+		slotSchedules[schedule.SlotId] = append(slotSchedules[schedule.SlotId], schedule)
+	}
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return 0, err
+	}
+	entries := make(map[int]tplinkapi.BandwidthControlEntry)
+	for _, entry := range details.Entries {
+		entries[entry.Id] = entry
+	}
+
+	changed := 0
+	for _, slot := range slots {
+		if !slot.Enabled || slot.StartIp == "" {
+			continue
+		}
+		entry, found := entries[slot.RemoteId]
+		if !found {
+			api.logger.Warn("bandwidth entry of slot not found", "slot", slot.Id, "entry", slot.RemoteId)
+			continue
+		}
+		limits := entryLimits(slotEntry(slot))
+		for _, schedule := range slotSchedules[slot.Id] {
+			if scheduleActive(schedule, now) {
+				limits = scheduleLimits(schedule)
+				break
+			}
+		}
+		if entryLimits(entry) == limits {
+			continue
+		}
+
+		updated := entry
+		updated.UpMin, updated.UpMax = limits.UpMin, limits.UpMax
+		updated.DownMin, updated.DownMax = limits.DownMin, limits.DownMax
+		id, err := api.replaceEntry(ctx, slot, entry, updated)
+		if id != 0 {
+			slot.RemoteId = id
+			if storeErr := api.store.BandwidthSlotStore.UpdateContext(ctx, slot); err == nil {
+				err = storeErr
+			}
+		}
+		if err != nil {
+			return changed, fmt.Errorf("slot %d: %w", slot.Id, err)
+		}
+		api.logger.Info("slot limits applied", "slot", slot.Id, "up_max", limits.UpMax, "down_max", limits.DownMax)
+		changed++
+	}
+	return changed, nil
+}
+
+func (api RouterApi) RunScheduler(interval time.Duration) error {
+	return api.RunSchedulerContext(context.Background(), interval)
+}
+
+// RunSchedulerContext applies schedules every interval until ctx is done.
+// Failed runs are logged and retried on the next tick.
+func (api RouterApi) RunSchedulerContext(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := api.ApplySchedulesContext(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			api.logger.Warn("applying schedules failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
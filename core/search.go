@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+// SearchResult is a device matching a search. A user matching by name who
+// has no devices is returned with DeviceId 0 and only UserId and User set.
+type SearchResult struct {
+	DeviceId int
+	Alias    string
+	Mac      string
+	UserId   int
+	User     string
+	IP       string
+	Blocked  bool
+}
+
+func (api RouterApi) FindDevices(term string) ([]SearchResult, error) {
+	return api.FindDevicesContext(context.Background(), term)
+}
+
+// FindDevicesContext finds devices by user name, alias, full or partial MAC
+// address, or whole octets of a reserved IP address.
+func (api RouterApi) FindDevicesContext(ctx context.Context, term string) ([]SearchResult, error) {
+	service := api.withContext(ctx)
+	results := make([]SearchResult, 0)
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return results, &SoftError{Message: "Search term is required"}
+	}
+
+	devices := make([]storage.Device, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.DeviceStore.SearchContext(ctx, term, pageSizeAll, pageNumber)
+		if err != nil {
+			return results, err
+		}
+		devices = append(devices, page...)
+		if len(page) < pageSizeAll {
+			break
+		}
+	}
+
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return results, err
+	}
+	addresses := make(map[string]string, len(reservations))
+	for _, resv := range reservations {
+		addresses[strings.ToUpper(resv.Mac)] = resv.IP
+	}
+
+	found := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		if device.Id != 0 {
+			found[strings.ToUpper(device.Mac)] = true
+		}
+	}
+	byIp := make([]string, 0)
+	addressTerm := isAddressTerm(term)
+	for _, resv := range reservations {
+		mac := strings.ToUpper(resv.Mac)
+		if addressTerm && matchesAddress(resv.IP, term) && !found[mac] {
+			byIp = append(byIp, mac)
+			found[mac] = true
+		}
+	}
+	if len(byIp) > 0 {
+		matches, err := api.store.DeviceStore.ReadManyByMacContext(ctx, byIp)
+		if err != nil {
+			return results, err
+		}
+		devices = append(devices, matches...)
+	}
+
+	blockedAddresses, err := api.getBlockedMacAddresses(ctx)
+	if err != nil {
+		return results, err
+	}
+	blocked := make(map[string]bool, len(blockedAddresses))
+	for _, mac := range blockedAddresses {
+		blocked[strings.ToUpper(mac)] = true
+	}
+
+	for _, device := range devices {
+		user, err := device.GetUser(api.store.UserStore)
+		if err != nil {
+			return results, err
+		}
+		result := SearchResult{UserId: user.Id, User: user.Name}
+		// The search returns a row without a device for a matching user
+		// who has none, so there is no address or access to look up.
+		if device.Id != 0 {
+			mac := strings.ToUpper(device.Mac)
+			result.DeviceId = device.Id
+			result.Alias = device.Alias
+			result.Mac = device.Mac
+			result.IP = addresses[mac]
+			result.Blocked = blocked[mac]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// isAddressTerm reports whether term looks like part of an IPv4 address: up
+// to four dot-separated octets of digits only.
+func isAddressTerm(term string) bool {
+	octets := strings.Split(term, ".")
+	if len(octets) > 4 {
+		return false
+	}
+	for _, octet := range octets {
+		if octet == "" || len(octet) > 3 || strings.Trim(octet, "0123456789") != "" {
+			return false
+		}
+		if n, _ := strconv.Atoi(octet); n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAddress reports whether term is a run of whole octets of ip, so
+// "0.1" matches 192.168.0.1 but not 192.168.0.10.
+func matchesAddress(ip, term string) bool {
+	return strings.Contains("."+ip+".", "."+term+".")
+}
@@ -0,0 +1,32 @@
+package core
+
+import "testing"
+
+func TestMatchesAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		term string
+		want bool
+	}{
+		{ip: "192.168.0.1", term: "192.168.0.1", want: true},
+		{ip: "192.168.0.1", term: "0.1", want: true},
+		{ip: "192.168.0.1", term: "168", want: true},
+		{ip: "192.168.0.1", term: "192.168", want: true},
+		{ip: "192.168.0.10", term: "1", want: false},
+		{ip: "192.168.0.10", term: "0.1", want: false},
+		{ip: "192.168.0.10", term: "92.168", want: false},
+		{ip: "192.168.0.10", term: "168.0.10", want: true},
+		{ip: "192.168.0.10", term: "phone", want: false},
+		{ip: "192.168.0.10", term: "0.", want: false},
+		{ip: "192.168.0.10", term: ".10", want: false},
+		{ip: "192.168.0.10", term: "+10", want: false},
+		{ip: "10.0.0.10", term: "10.0.0.10.1", want: false},
+		{ip: "10.0.0.1", term: "256", want: false},
+	}
+	for _, tt := range tests {
+		got := isAddressTerm(tt.term) && matchesAddress(tt.ip, tt.term)
+		if got != tt.want {
+			t.Errorf("matching %q against %s = %v, want %v", tt.term, tt.ip, got, tt.want)
+		}
+	}
+}
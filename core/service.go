@@ -0,0 +1,650 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+type RouterService interface {
+	GetRouterInfo() (tplinkapi.RouterInfo, error)
+	GetClientInfo() (tplinkapi.Client, error)
+	GetLanConfig() (tplinkapi.LanConfig, error)
+	GetStatistics() (tplinkapi.ClientStatistics, error)
+	GetAddressReservations() ([]tplinkapi.ClientReservation, error)
+	GetIpMacBindings() ([]tplinkapi.ClientReservation, error)
+	MakeIpAddressReservation(client tplinkapi.Client) error
+	DeleteIpAddressReservation(macAddress string) error
+	GetBandwidthControlDetails() (tplinkapi.BandwidthControlDetail, error)
+	ToggleBandwidthControl(config tplinkapi.BandwidthControlDetail) error
+	GetBandwidthControlEntry(id int) (tplinkapi.BandwidthControlEntry, error)
+	AddBwControlEntry(entry tplinkapi.BandwidthControlEntry) (int, error)
+	DeleteBwControlEntry(entryId int) error
+	ToggleInternetAccessControl(cfg tplinkapi.InternetAccessControl) error
+	AddAccessControlHost(host tplinkapi.AccessControlHostFormatter) (int, error)
+	RemoveAccessControlHost(id int) error
+	AddAccessControlRule(host tplinkapi.AccessControlHostFormatter) (int, error)
+	GetAccessControlHosts() (tplinkapi.AccessControlHostMap, error)
+	GetAccessControlRules() ([]tplinkapi.AccessControlRule, error)
+	DeleteAccessControlRule(id int) error
+	GetDhcpConfiguration() (tplinkapi.DhcpConfiguration, error)
+	UpdateDhcpConfiguration(cfg tplinkapi.DhcpConfiguration) error
+	Logout() error
+}
+
+type Call struct {
+	Context context.Context
+	Name    string
+	Write   bool
+	Args    []interface{}
+}
+
+// Interceptor runs around every router call. next performs the call and
+// returns its result, and may be invoked more than once. An interceptor that
+// stops waiting for next must not read the abandoned call's result.
+type Interceptor interface {
+	Intercept(call Call, next func() (interface{}, error)) (interface{}, error)
+}
+
+type interceptedService struct {
+	next        RouterService
+	interceptor Interceptor
+	ctx         context.Context
+}
+
+func withInterceptor(service RouterService, interceptor Interceptor) RouterService {
+	return interceptedService{next: service, interceptor: interceptor, ctx: context.Background()}
+}
+
+// contextBinder is implemented by the services that wrap another one, so
+// that the context of a call reaches every interceptor below it.
+type contextBinder interface {
+	withContext(ctx context.Context) RouterService
+}
+
+func bindContext(service RouterService, ctx context.Context) RouterService {
+	if binder, ok := service.(contextBinder); ok {
+		return binder.withContext(ctx)
+	}
+	return service
+}
+
+func (s interceptedService) withContext(ctx context.Context) RouterService {
+	return interceptedService{next: bindContext(s.next, ctx), interceptor: s.interceptor, ctx: ctx}
+}
+
+// intercept passes a call through the interceptor. Results travel back as
+// return values so a call still running after the interceptor gave up on it
+// cannot write to anything the caller reads.
+func intercept[T any](s interceptedService, call Call, next func() (T, error)) (T, error) {
+	call.Context = s.ctx
+	result, err := s.interceptor.Intercept(call, func() (interface{}, error) {
+		return next()
+	})
+	value, _ := result.(T)
+	return value, err
+}
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// runUntilDone runs next in the background and stops waiting for it once
+// ctx is done. The abandoned call finishes on its own and its result is
+// dropped.
+func runUntilDone(ctx context.Context, next func() (interface{}, error)) (interface{}, error) {
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := next()
+		done <- callResult{value: value, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// contextGuard abandons a router call once its context is done. The context
+// is also bound to the services below so that retries stop, but the
+// underlying HTTP request cannot be cancelled and finishes in the background.
+type contextGuard struct {
+	ctx context.Context
+}
+
+func (guard contextGuard) Intercept(call Call, next func() (interface{}, error)) (interface{}, error) {
+	if err := guard.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return runUntilDone(guard.ctx, next)
+}
+
+func (api RouterApi) withContext(ctx context.Context) RouterService {
+	if ctx.Done() == nil {
+		return api.service
+	}
+	return withInterceptor(bindContext(api.service, ctx), contextGuard{ctx: ctx})
+}
+
+func (s interceptedService) GetRouterInfo() (tplinkapi.RouterInfo, error) {
+	return intercept(s, Call{Name: "GetRouterInfo"}, s.next.GetRouterInfo)
+}
+
+func (s interceptedService) GetClientInfo() (tplinkapi.Client, error) {
+	return intercept(s, Call{Name: "GetClientInfo"}, s.next.GetClientInfo)
+}
+
+func (s interceptedService) GetLanConfig() (tplinkapi.LanConfig, error) {
+	return intercept(s, Call{Name: "GetLanConfig"}, s.next.GetLanConfig)
+}
+
+func (s interceptedService) GetStatistics() (tplinkapi.ClientStatistics, error) {
+	return intercept(s, Call{Name: "GetStatistics"}, s.next.GetStatistics)
+}
+
+func (s interceptedService) GetAddressReservations() ([]tplinkapi.ClientReservation, error) {
+	return intercept(s, Call{Name: "GetAddressReservations"}, s.next.GetAddressReservations)
+}
+
+func (s interceptedService) GetIpMacBindings() ([]tplinkapi.ClientReservation, error) {
+	return intercept(s, Call{Name: "GetIpMacBindings"}, s.next.GetIpMacBindings)
+}
+
+func (s interceptedService) MakeIpAddressReservation(client tplinkapi.Client) error {
+	call := Call{
+		Name:  "MakeIpAddressReservation",
+		Write: true,
+		Args:  []interface{}{client},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.MakeIpAddressReservation(client)
+	})
+	return err
+}
+
+func (s interceptedService) DeleteIpAddressReservation(macAddress string) error {
+	call := Call{
+		Name:  "DeleteIpAddressReservation",
+		Write: true,
+		Args:  []interface{}{macAddress},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.DeleteIpAddressReservation(macAddress)
+	})
+	return err
+}
+
+func (s interceptedService) GetBandwidthControlDetails() (tplinkapi.BandwidthControlDetail, error) {
+	return intercept(s, Call{Name: "GetBandwidthControlDetails"}, s.next.GetBandwidthControlDetails)
+}
+
+func (s interceptedService) ToggleBandwidthControl(config tplinkapi.BandwidthControlDetail) error {
+	call := Call{
+		Name:  "ToggleBandwidthControl",
+		Write: true,
+		Args:  []interface{}{config},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.ToggleBandwidthControl(config)
+	})
+	return err
+}
+
+func (s interceptedService) GetBandwidthControlEntry(id int) (tplinkapi.BandwidthControlEntry, error) {
+	call := Call{
+		Name: "GetBandwidthControlEntry",
+		Args: []interface{}{id},
+	}
+	return intercept(s, call, func() (tplinkapi.BandwidthControlEntry, error) {
+		return s.next.GetBandwidthControlEntry(id)
+	})
+}
+
+func (s interceptedService) AddBwControlEntry(entry tplinkapi.BandwidthControlEntry) (int, error) {
+	call := Call{
+		Name:  "AddBwControlEntry",
+		Write: true,
+		Args:  []interface{}{entry},
+	}
+	return intercept(s, call, func() (int, error) {
+		return s.next.AddBwControlEntry(entry)
+	})
+}
+
+func (s interceptedService) DeleteBwControlEntry(entryId int) error {
+	call := Call{
+		Name:  "DeleteBwControlEntry",
+		Write: true,
+		Args:  []interface{}{entryId},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.DeleteBwControlEntry(entryId)
+	})
+	return err
+}
+
+func (s interceptedService) ToggleInternetAccessControl(cfg tplinkapi.InternetAccessControl) error {
+	call := Call{
+		Name:  "ToggleInternetAccessControl",
+		Write: true,
+		Args:  []interface{}{cfg},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.ToggleInternetAccessControl(cfg)
+	})
+	return err
+}
+
+func (s interceptedService) AddAccessControlHost(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	call := Call{
+		Name:  "AddAccessControlHost",
+		Write: true,
+		Args:  []interface{}{host},
+	}
+	return intercept(s, call, func() (int, error) {
+		return s.next.AddAccessControlHost(host)
+	})
+}
+
+func (s interceptedService) RemoveAccessControlHost(id int) error {
+	call := Call{
+		Name:  "RemoveAccessControlHost",
+		Write: true,
+		Args:  []interface{}{id},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.RemoveAccessControlHost(id)
+	})
+	return err
+}
+
+func (s interceptedService) AddAccessControlRule(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	call := Call{
+		Name:  "AddAccessControlRule",
+		Write: true,
+		Args:  []interface{}{host},
+	}
+	return intercept(s, call, func() (int, error) {
+		return s.next.AddAccessControlRule(host)
+	})
+}
+
+func (s interceptedService) GetAccessControlHosts() (tplinkapi.AccessControlHostMap, error) {
+	return intercept(s, Call{Name: "GetAccessControlHosts"}, s.next.GetAccessControlHosts)
+}
+
+func (s interceptedService) GetAccessControlRules() ([]tplinkapi.AccessControlRule, error) {
+	return intercept(s, Call{Name: "GetAccessControlRules"}, s.next.GetAccessControlRules)
+}
+
+func (s interceptedService) DeleteAccessControlRule(id int) error {
+	call := Call{
+		Name:  "DeleteAccessControlRule",
+		Write: true,
+		Args:  []interface{}{id},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.DeleteAccessControlRule(id)
+	})
+	return err
+}
+
+func (s interceptedService) GetDhcpConfiguration() (tplinkapi.DhcpConfiguration, error) {
+	return intercept(s, Call{Name: "GetDhcpConfiguration"}, s.next.GetDhcpConfiguration)
+}
+
+func (s interceptedService) UpdateDhcpConfiguration(cfg tplinkapi.DhcpConfiguration) error {
+	call := Call{
+		Name:  "UpdateDhcpConfiguration",
+		Write: true,
+		Args:  []interface{}{cfg},
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.UpdateDhcpConfiguration(cfg)
+	})
+	return err
+}
+
+func (s interceptedService) Logout() error {
+	call := Call{
+		Name:  "Logout",
+		Write: true,
+	}
+	_, err := intercept(s, call, func() (interface{}, error) {
+		return nil, s.next.Logout()
+	})
+	return err
+}
+
+// dryRunService passes reads through to the router and reports writes
+// without sending them.
+type dryRunService struct {
+	RouterService
+	out io.Writer
+}
+
+func (s dryRunService) withContext(ctx context.Context) RouterService {
+	return dryRunService{RouterService: bindContext(s.RouterService, ctx), out: s.out}
+}
+
+func (s dryRunService) log(format string, a ...interface{}) {
+	fmt.Fprintf(s.out, "[dry-run] router: "+format+"\n", a...)
+}
+
+func (s dryRunService) MakeIpAddressReservation(client tplinkapi.Client) error {
+	s.log("reserve %s for %s", client.IP, client.Mac)
+	return nil
+}
+
+func (s dryRunService) DeleteIpAddressReservation(macAddress string) error {
+	s.log("delete address reservation for %s", macAddress)
+	return nil
+}
+
+func (s dryRunService) ToggleBandwidthControl(config tplinkapi.BandwidthControlDetail) error {
+	s.log("set bandwidth control enabled=%v up=%d down=%d", config.Enabled, config.UpTotal, config.DownTotal)
+	return nil
+}
+
+func (s dryRunService) AddBwControlEntry(entry tplinkapi.BandwidthControlEntry) (int, error) {
+	s.log(
+		"add bandwidth entry %s - %s Up:%d/%d Down:%d/%d",
+		entry.StartIp, entry.EndIp, entry.UpMin, entry.UpMax, entry.DownMin, entry.DownMax,
+	)
+	return 0, nil
+}
+
+func (s dryRunService) DeleteBwControlEntry(entryId int) error {
+	s.log("delete bandwidth entry %d", entryId)
+	return nil
+}
+
+func (s dryRunService) ToggleInternetAccessControl(cfg tplinkapi.InternetAccessControl) error {
+	s.log("set internet access control enabled=%v default deny=%v", cfg.Enabled, cfg.DefaultDeny)
+	return nil
+}
+
+func (s dryRunService) AddAccessControlHost(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	s.log("add access control host %s", host.GetRef())
+	return 0, nil
+}
+
+func (s dryRunService) RemoveAccessControlHost(id int) error {
+	s.log("remove access control host %d", id)
+	return nil
+}
+
+func (s dryRunService) AddAccessControlRule(host tplinkapi.AccessControlHostFormatter) (int, error) {
+	s.log("add access control rule for host %s", host.GetRef())
+	return 0, nil
+}
+
+func (s dryRunService) DeleteAccessControlRule(id int) error {
+	s.log("delete access control rule %d", id)
+	return nil
+}
+
+func (s dryRunService) UpdateDhcpConfiguration(cfg tplinkapi.DhcpConfiguration) error {
+	s.log("set dhcp pool %s - %s", cfg.MinAddress, cfg.MaxAddress)
+	return nil
+}
+
+func dryRunLog(out io.Writer, format string, a ...interface{}) {
+	fmt.Fprintf(out, "[dry-run] db: "+format+"\n", a...)
+}
+
+type dryRunUserStore struct {
+	storage.UserStorage
+	out io.Writer
+}
+
+func (s dryRunUserStore) Create(user *storage.User) error {
+	return s.CreateContext(context.Background(), user)
+}
+
+func (s dryRunUserStore) CreateContext(ctx context.Context, user *storage.User) error {
+	dryRunLog(s.out, "insert users row name=%q", user.Name)
+	return nil
+}
+
+func (s dryRunUserStore) Update(user storage.User) error {
+	return s.UpdateContext(context.Background(), user)
+}
+
+func (s dryRunUserStore) UpdateContext(ctx context.Context, user storage.User) error {
+	dryRunLog(s.out, "update users row %d name=%q contact=%q", user.Id, user.Name, user.Contact)
+	return nil
+}
+
+func (s dryRunUserStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunUserStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete users row %d", id)
+	return nil
+}
+
+type dryRunDeviceStore struct {
+	storage.DeviceStorage
+	out io.Writer
+}
+
+func (s dryRunDeviceStore) Create(device *storage.Device) error {
+	return s.CreateContext(context.Background(), device)
+}
+
+func (s dryRunDeviceStore) CreateContext(ctx context.Context, device *storage.Device) error {
+	dryRunLog(s.out, "insert devices row mac=%s alias=%q user=%d", device.Mac, device.Alias, device.UserId)
+	return nil
+}
+
+func (s dryRunDeviceStore) Update(device storage.Device) error {
+	return s.UpdateContext(context.Background(), device)
+}
+
+func (s dryRunDeviceStore) UpdateContext(ctx context.Context, device storage.Device) error {
+	dryRunLog(
+		s.out, "update devices row %d mac=%s alias=%q user=%d type=%q tags=%s",
+		device.Id, device.Mac, device.Alias, device.UserId, device.Type, strings.Join(device.Tags, ","),
+	)
+	return nil
+}
+
+func (s dryRunDeviceStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunDeviceStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete devices row %d", id)
+	return nil
+}
+
+func (s dryRunDeviceStore) DeleteByUserId(userId int) error {
+	return s.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (s dryRunDeviceStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
+	dryRunLog(s.out, "delete devices rows of user %d", userId)
+	return nil
+}
+
+type dryRunBandwidthSlotStore struct {
+	storage.BandwidthSlotStorage
+	out io.Writer
+}
+
+func (s dryRunBandwidthSlotStore) Create(slot *storage.BandwidthSlot) error {
+	return s.CreateContext(context.Background(), slot)
+}
+
+func (s dryRunBandwidthSlotStore) CreateContext(ctx context.Context, slot *storage.BandwidthSlot) error {
+	dryRunLog(s.out, "insert bw_slots row user=%d remote=%d", slot.UserId, slot.RemoteId)
+	return nil
+}
+
+func (s dryRunBandwidthSlotStore) Update(slot storage.BandwidthSlot) error {
+	return s.UpdateContext(context.Background(), slot)
+}
+
+func (s dryRunBandwidthSlotStore) UpdateContext(ctx context.Context, slot storage.BandwidthSlot) error {
+	dryRunLog(s.out, "update bw_slots row %d user=%d remote=%d", slot.Id, slot.UserId, slot.RemoteId)
+	return nil
+}
+
+func (s dryRunBandwidthSlotStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunBandwidthSlotStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete bw_slots row %d", id)
+	return nil
+}
+
+func (s dryRunBandwidthSlotStore) DeleteByUserId(userId int) error {
+	return s.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (s dryRunBandwidthSlotStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
+	dryRunLog(s.out, "delete bw_slots rows of user %d", userId)
+	return nil
+}
+
+func (s dryRunBandwidthSlotStore) ClearPlan(planId int) error {
+	return s.ClearPlanContext(context.Background(), planId)
+}
+
+func (s dryRunBandwidthSlotStore) ClearPlanContext(ctx context.Context, planId int) error {
+	dryRunLog(s.out, "clear plan %d from bw_slots rows", planId)
+	return nil
+}
+
+type dryRunPlanStore struct {
+	storage.PlanStorage
+	out io.Writer
+}
+
+func (s dryRunPlanStore) Create(plan *storage.Plan) error {
+	return s.CreateContext(context.Background(), plan)
+}
+
+func (s dryRunPlanStore) CreateContext(ctx context.Context, plan *storage.Plan) error {
+	dryRunLog(s.out, "insert plans row name=%q", plan.Name)
+	return nil
+}
+
+func (s dryRunPlanStore) Update(plan storage.Plan) error {
+	return s.UpdateContext(context.Background(), plan)
+}
+
+func (s dryRunPlanStore) UpdateContext(ctx context.Context, plan storage.Plan) error {
+	dryRunLog(s.out, "update plans row %d name=%q", plan.Id, plan.Name)
+	return nil
+}
+
+func (s dryRunPlanStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunPlanStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete plans row %d", id)
+	return nil
+}
+
+type dryRunScheduleStore struct {
+	storage.SlotScheduleStorage
+	out io.Writer
+}
+
+func (s dryRunScheduleStore) Create(schedule *storage.SlotSchedule) error {
+	return s.CreateContext(context.Background(), schedule)
+}
+
+func (s dryRunScheduleStore) CreateContext(ctx context.Context, schedule *storage.SlotSchedule) error {
+	dryRunLog(s.out, "insert slot_schedules row slot=%d %s-%s", schedule.SlotId, schedule.Start, schedule.End)
+	return nil
+}
+
+func (s dryRunScheduleStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunScheduleStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete slot_schedules row %d", id)
+	return nil
+}
+
+func (s dryRunScheduleStore) DeleteBySlotId(slotId int) error {
+	return s.DeleteBySlotIdContext(context.Background(), slotId)
+}
+
+func (s dryRunScheduleStore) DeleteBySlotIdContext(ctx context.Context, slotId int) error {
+	dryRunLog(s.out, "delete slot_schedules rows of slot %d", slotId)
+	return nil
+}
+
+func (s dryRunScheduleStore) DeleteByUserId(userId int) error {
+	return s.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (s dryRunScheduleStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
+	dryRunLog(s.out, "delete slot_schedules rows of user %d", userId)
+	return nil
+}
+
+type dryRunGroupStore struct {
+	storage.GroupStorage
+	out io.Writer
+}
+
+func (s dryRunGroupStore) Create(group *storage.Group) error {
+	return s.CreateContext(context.Background(), group)
+}
+
+func (s dryRunGroupStore) CreateContext(ctx context.Context, group *storage.Group) error {
+	dryRunLog(s.out, "insert user_groups row name=%q", group.Name)
+	return nil
+}
+
+func (s dryRunGroupStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s dryRunGroupStore) DeleteContext(ctx context.Context, id int) error {
+	dryRunLog(s.out, "delete user_groups row %d and its members", id)
+	return nil
+}
+
+func (s dryRunGroupStore) AddMember(groupId, userId int) error {
+	return s.AddMemberContext(context.Background(), groupId, userId)
+}
+
+func (s dryRunGroupStore) AddMemberContext(ctx context.Context, groupId, userId int) error {
+	dryRunLog(s.out, "insert user_group_members row group=%d user=%d", groupId, userId)
+	return nil
+}
+
+func (s dryRunGroupStore) RemoveMember(groupId, userId int) error {
+	return s.RemoveMemberContext(context.Background(), groupId, userId)
+}
+
+func (s dryRunGroupStore) RemoveMemberContext(ctx context.Context, groupId, userId int) error {
+	dryRunLog(s.out, "delete user_group_members row group=%d user=%d", groupId, userId)
+	return nil
+}
+
+func (s dryRunGroupStore) RemoveUser(userId int) error {
+	return s.RemoveUserContext(context.Background(), userId)
+}
+
+func (s dryRunGroupStore) RemoveUserContext(ctx context.Context, userId int) error {
+	dryRunLog(s.out, "delete user_group_members rows of user %d", userId)
+	return nil
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSessionIdleTimeout = 30 * time.Second
+
+func isSessionExpired(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.HasPrefix(message, "401") || strings.HasPrefix(message, "403")
+}
+
+// Session keeps a single admin session open on the router. The firmware only
+// allows one admin session, so calls are serialised and the session is
+// released once routerman has been idle so that the web UI can log in again.
+type Session struct {
+	mu          sync.Mutex
+	service     RouterService
+	active      bool
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	logger      *slog.Logger
+}
+
+func NewSession(service RouterService, idleTimeout time.Duration) *Session {
+	return &Session{service: service, idleTimeout: idleTimeout, logger: discardLogger()}
+}
+
+func (session *Session) Intercept(call Call, next func() (interface{}, error)) (interface{}, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.idleTimer != nil {
+		session.idleTimer.Stop()
+	}
+
+	result, err := next()
+	if isSessionExpired(err) {
+		session.logger.Info("router session expired, logging in again", "call", call.Name)
+		session.service.Logout()
+		result, err = next()
+	}
+
+	if call.Name == "Logout" {
+		session.active = false
+		return result, err
+	}
+	if err == nil {
+		session.active = true
+	}
+	if session.active && session.idleTimeout > 0 {
+		session.idleTimer = time.AfterFunc(session.idleTimeout, session.release)
+	}
+	return result, err
+}
+
+func (session *Session) release() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.logout()
+}
+
+func (session *Session) logout() error {
+	if !session.active {
+		return nil
+	}
+	session.active = false
+	session.logger.Debug("releasing router session")
+	return session.service.Logout()
+}
+
+func (session *Session) Close() error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.idleTimer != nil {
+		session.idleTimer.Stop()
+	}
+	return session.logout()
+}
@@ -0,0 +1,533 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/omushpapa/routerman/storage"
+	"github.com/omushpapa/tplinkapi"
+)
+
+type UserSlot struct {
+	Slot  storage.BandwidthSlot
+	Entry tplinkapi.BandwidthControlEntry
+}
+
+// Limits returns the slot's own limits. The router entry has other limits
+// while a schedule is active.
+func (slot UserSlot) Limits() SlotLimits {
+	if slot.Slot.StartIp == "" {
+		return entryLimits(slot.Entry)
+	}
+	return entryLimits(slotEntry(slot.Slot))
+}
+
+func (api RouterApi) GetSlots() ([]UserSlot, error) {
+	return api.GetSlotsContext(context.Background())
+}
+
+// GetSlotsContext returns every slot of the router with its bandwidth entry.
+func (api RouterApi) GetSlotsContext(ctx context.Context) ([]UserSlot, error) {
+	slots, err := api.getAllSlots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return api.withEntries(ctx, slots)
+}
+
+// slotEntry returns the bandwidth entry stored with a slot. For enabled
+// slots the router entry is authoritative.
+func slotEntry(slot storage.BandwidthSlot) tplinkapi.BandwidthControlEntry {
+	return tplinkapi.BandwidthControlEntry{
+		Id:      slot.RemoteId,
+		Enabled: slot.Enabled,
+		StartIp: slot.StartIp,
+		EndIp:   slot.EndIp,
+		UpMin:   slot.UpMin,
+		UpMax:   slot.UpMax,
+		DownMin: slot.DownMin,
+		DownMax: slot.DownMax,
+	}
+}
+
+func setSlotEntry(slot *storage.BandwidthSlot, entry tplinkapi.BandwidthControlEntry) {
+	slot.StartIp, slot.EndIp = entry.StartIp, entry.EndIp
+	slot.UpMin, slot.UpMax = entry.UpMin, entry.UpMax
+	slot.DownMin, slot.DownMax = entry.DownMin, entry.DownMax
+	slot.Enabled = entry.Enabled
+}
+
+func (api RouterApi) withEntries(ctx context.Context, slots []storage.BandwidthSlot) ([]UserSlot, error) {
+	userSlots := make([]UserSlot, 0, len(slots))
+	if len(slots) == 0 {
+		return userSlots, nil
+	}
+
+	ids := make([]int, 0, len(slots))
+	for _, slot := range slots {
+		if slot.Enabled {
+			ids = append(ids, slot.RemoteId)
+		}
+	}
+	entries, err := api.GetBwControlEntriesByListContext(ctx, ids)
+	if err != nil {
+		return userSlots, err
+	}
+	for _, slot := range slots {
+		entry := slotEntry(slot)
+		if slot.Enabled {
+			entry, entries = entries[0], entries[1:]
+		}
+		userSlots = append(userSlots, UserSlot{Slot: slot, Entry: entry})
+	}
+	return userSlots, nil
+}
+
+func (api RouterApi) GetSlot(slotId int) (UserSlot, error) {
+	return api.GetSlotContext(context.Background(), slotId)
+}
+
+func (api RouterApi) GetSlotContext(ctx context.Context, slotId int) (UserSlot, error) {
+	slot, entry, err := api.getSlotEntry(ctx, slotId)
+	return UserSlot{Slot: slot, Entry: entry}, err
+}
+
+func (api RouterApi) getSlotEntry(ctx context.Context, slotId int) (storage.BandwidthSlot, tplinkapi.BandwidthControlEntry, error) {
+	slot, err := api.store.BandwidthSlotStore.ReadContext(ctx, slotId)
+	if err != nil {
+		return slot, tplinkapi.BandwidthControlEntry{}, err
+	}
+	if !slot.Enabled {
+		return slot, slotEntry(slot), nil
+	}
+	entries, err := api.GetBwControlEntriesByListContext(ctx, []int{slot.RemoteId})
+	if err != nil {
+		return slot, tplinkapi.BandwidthControlEntry{}, err
+	}
+	return slot, entries[0], nil
+}
+
+// getDisabledSlots returns the slots without a router entry whose range is
+// still reserved.
+func (api RouterApi) getDisabledSlots(ctx context.Context) ([]storage.BandwidthSlot, error) {
+	disabled := make([]storage.BandwidthSlot, 0)
+	slots, err := api.getAllSlots(ctx)
+	if err != nil {
+		return disabled, err
+	}
+	for _, slot := range slots {
+		if !slot.Enabled && slot.StartIp != "" {
+			disabled = append(disabled, slot)
+		}
+	}
+	return disabled, nil
+}
+
+// saveSlot makes the router match updated and stores it with the slot. A
+// disabled slot keeps no entry on the router.
+func (api RouterApi) saveSlot(ctx context.Context, slot storage.BandwidthSlot, old, updated tplinkapi.BandwidthControlEntry) (storage.BandwidthSlot, error) {
+	id, err := api.replaceEntry(ctx, slot, old, updated)
+	if err != nil {
+		if id != 0 {
+			slot.RemoteId = id
+			api.store.BandwidthSlotStore.UpdateContext(ctx, slot)
+		}
+		return slot, err
+	}
+	slot.RemoteId = id
+	setSlotEntry(&slot, updated)
+	err = api.store.BandwidthSlotStore.UpdateContext(ctx, slot)
+	return slot, err
+}
+
+// replaceEntry swaps the router entry of a slot for updated and returns the
+// new entry id, or 0 when updated is disabled. The router has no way to edit
+// an entry, so the old one is deleted and a new one added; if adding fails
+// the old entry is restored and its new id returned with the error.
+func (api RouterApi) replaceEntry(ctx context.Context, slot storage.BandwidthSlot, old, updated tplinkapi.BandwidthControlEntry) (int, error) {
+	service := api.withContext(ctx)
+	if slot.Enabled {
+		if err := service.DeleteBwControlEntry(old.Id); err != nil {
+			return 0, err
+		}
+	}
+	if !updated.Enabled {
+		return 0, nil
+	}
+	id, err := service.AddBwControlEntry(updated)
+	if err != nil {
+		if slot.Enabled {
+			if restoredId, restoreErr := service.AddBwControlEntry(old); restoreErr == nil {
+				return restoredId, err
+			}
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// checkSlotRange makes sure r lies inside the LAN and does not take the
+// router address, the DHCP pool or the range of another slot or bandwidth
+// entry.
+func (api RouterApi) checkSlotRange(ctx context.Context, r AddrRange, self storage.BandwidthSlot) error {
+	service := api.withContext(ctx)
+	info, err := service.GetRouterInfo()
+	if err != nil {
+		return err
+	}
+	routerAddr, err := parseIPv4(info.IP)
+	if err != nil {
+		return err
+	}
+	lanConfig, err := service.GetLanConfig()
+	if err != nil {
+		return err
+	}
+	hosts, err := hostRange(netip.PrefixFrom(routerAddr, lanConfig.GetPrefix()))
+	if err != nil {
+		return err
+	}
+	if !hosts.Contains(r.Start) || !hosts.Contains(r.End) {
+		return &SoftError{Message: fmt.Sprintf("Range %s is outside the LAN %s", r, hosts)}
+	}
+	if r.Contains(routerAddr) {
+		return &SoftError{Message: fmt.Sprintf("Range %s includes the router address %s", r, routerAddr)}
+	}
+
+	dhcpConfig, err := service.GetDhcpConfiguration()
+	if err != nil {
+		return err
+	}
+	pool, err := NewAddrRange(dhcpConfig.MinAddress, dhcpConfig.MaxAddress)
+	if err != nil {
+		return err
+	}
+	if overlaps(r, pool) {
+		return &SoftError{Message: fmt.Sprintf("Range %s overlaps the DHCP pool %s", r, pool)}
+	}
+
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return err
+	}
+	entries := details.Entries
+	disabled, err := api.getDisabledSlots(ctx)
+	if err != nil {
+		return err
+	}
+	for _, slot := range disabled {
+		if slot.Id != self.Id {
+			entries = append(entries, slotEntry(slot))
+		}
+	}
+	for _, entry := range entries {
+		if self.Enabled && entry.Id == self.RemoteId {
+			continue
+		}
+		other, err := NewAddrRange(entry.StartIp, entry.EndIp)
+		if err != nil {
+			return err
+		}
+		if overlaps(r, other) {
+			return &SoftError{Message: fmt.Sprintf("Range %s overlaps bandwidth entry %s", r, other)}
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b AddrRange) bool {
+	return !a.End.Less(b.Start) && !b.End.Less(a.Start)
+}
+
+// reservationMove replaces the reservation of mac at from with client,
+// which may change the address, the MAC address or both.
+type reservationMove struct {
+	client tplinkapi.Client
+	mac    string
+	from   string
+}
+
+// planReservations finds new addresses in r for the reservations that lie
+// in old but not in r. Moved reservations keep their offset from the start
+// of the range when that address is free.
+func planReservations(reservations []tplinkapi.ClientReservation, old, r AddrRange) ([]reservationMove, error) {
+	var free AddrSet
+	free.Add(r)
+	pending := make([]tplinkapi.ClientReservation, 0)
+	for _, resv := range reservations {
+		addr, err := parseIPv4(resv.IP)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case r.Contains(addr) && !old.Contains(addr):
+			return nil, &SoftError{Message: fmt.Sprintf("Address %s is reserved for %s", resv.IP, resv.Mac)}
+		case r.Contains(addr):
+			free.Remove(SingleAddr(addr))
+		case old.Contains(addr):
+			pending = append(pending, resv)
+		}
+	}
+
+	moves := make([]reservationMove, 0, len(pending))
+	for _, resv := range pending {
+		addr, _ := parseIPv4(resv.IP)
+		target := uint32ToAddr(addrToUint32(r.Start) + addrToUint32(addr) - addrToUint32(old.Start))
+		if !free.Contains(target) {
+			block, err := free.Allocate(1, FirstFit)
+			if err != nil {
+				return nil, &SoftError{Message: fmt.Sprintf("Range %s has no room for %d reserved devices", r, len(pending))}
+			}
+			target = block.Start
+		}
+		free.Remove(SingleAddr(target))
+
+		client, err := tplinkapi.NewClient(target.String(), resv.Mac)
+		if err != nil {
+			return nil, err
+		}
+		moves = append(moves, reservationMove{client: client, mac: resv.Mac, from: resv.IP})
+	}
+	return moves, nil
+}
+
+func (api RouterApi) ResizeSlot(slotId, numDevices int) error {
+	return api.ResizeSlotContext(context.Background(), slotId, numDevices)
+}
+
+// ResizeSlotContext keeps the start address of a slot and grows or shrinks
+// it to numDevices addresses.
+func (api RouterApi) ResizeSlotContext(ctx context.Context, slotId, numDevices int) error {
+	if numDevices < 1 {
+		return &SoftError{Message: "Number of devices must be at least 1"}
+	}
+	slot, entry, err := api.getSlotEntry(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	if slot.DeviceId != 0 && numDevices != 1 {
+		return &SoftError{Message: "A device slot holds a single address"}
+	}
+	start, err := parseIPv4(entry.StartIp)
+	if err != nil {
+		return err
+	}
+	end := uint32ToAddr(addrToUint32(start) + uint32(numDevices) - 1)
+	return api.relocateSlot(ctx, slotId, AddrRange{Start: start, End: end})
+}
+
+func (api RouterApi) MoveSlot(slotId int, startIP string) error {
+	return api.MoveSlotContext(context.Background(), slotId, startIP)
+}
+
+// MoveSlotContext moves a slot to begin at startIP, keeping its size.
+func (api RouterApi) MoveSlotContext(ctx context.Context, slotId int, startIP string) error {
+	start, err := parseIPv4(startIP)
+	if err != nil {
+		return &SoftError{Message: err.Error()}
+	}
+	_, entry, err := api.getSlotEntry(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	old, err := NewAddrRange(entry.StartIp, entry.EndIp)
+	if err != nil {
+		return err
+	}
+	end := uint32ToAddr(addrToUint32(start) + uint32(old.Size()) - 1)
+	return api.relocateSlot(ctx, slotId, AddrRange{Start: start, End: end})
+}
+
+func (api RouterApi) relocateSlot(ctx context.Context, slotId int, r AddrRange) error {
+	service := api.withContext(ctx)
+	slot, entry, err := api.getSlotEntry(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	old, err := NewAddrRange(entry.StartIp, entry.EndIp)
+	if err != nil {
+		return err
+	}
+	if old == r {
+		return nil
+	}
+	if err = api.checkSlotRange(ctx, r, slot); err != nil {
+		return err
+	}
+	reservations, err := service.GetAddressReservations()
+	if err != nil {
+		return err
+	}
+	moves, err := planReservations(reservations, old, r)
+	if err != nil {
+		return err
+	}
+
+	// Reservations move first so that a failure leaves the slot where it
+	// was. If the entry then cannot be moved, the reservations go back.
+	if err = api.moveReservations(ctx, moves); err != nil {
+		return err
+	}
+
+	// The stored limits are used, so a schedule active on the router entry
+	// does not become the slot's own limits. The scheduler reapplies it.
+	updated := slotEntry(slot)
+	updated.StartIp, updated.EndIp = r.Start.String(), r.End.String()
+	if _, err = api.saveSlot(ctx, slot, entry, updated); err != nil {
+		api.undoReservationMoves(ctx, moves)
+		return err
+	}
+	return nil
+}
+
+// moveReservations applies moves in order. When one fails, its reservation
+// is restored at the old address and the moves already made are undone.
+func (api RouterApi) moveReservations(ctx context.Context, moves []reservationMove) error {
+	service := api.withContext(ctx)
+	for i, move := range moves {
+		err := service.DeleteIpAddressReservation(move.mac)
+		if err == nil {
+			if err = service.MakeIpAddressReservation(move.client); err != nil {
+				api.restoreReservation(ctx, move)
+			}
+		}
+		if err != nil {
+			api.undoReservationMoves(ctx, moves[:i])
+			return fmt.Errorf("moving reservation of %s: %w", move.mac, err)
+		}
+		api.logger.Info("reservation moved", "mac", move.mac, "ip", move.from, "new_mac", move.client.Mac, "new_ip", move.client.IP)
+	}
+	return nil
+}
+
+// undoReservationMoves puts moved reservations back at their old addresses,
+// last move first.
+func (api RouterApi) undoReservationMoves(ctx context.Context, moves []reservationMove) {
+	service := api.withContext(ctx)
+	for i := len(moves) - 1; i >= 0; i-- {
+		move := moves[i]
+		if err := service.DeleteIpAddressReservation(move.client.Mac); err != nil {
+			api.logger.Warn("reservation not moved back", "mac", move.client.Mac, "address", move.client.IP, "error", err)
+			continue
+		}
+		api.restoreReservation(ctx, move)
+	}
+}
+
+func (api RouterApi) restoreReservation(ctx context.Context, move reservationMove) {
+	service := api.withContext(ctx)
+	client, err := tplinkapi.NewClient(move.from, move.mac)
+	if err == nil {
+		err = service.MakeIpAddressReservation(client)
+	}
+	if err != nil {
+		api.logger.Warn("reservation not restored", "mac", move.mac, "address", move.from, "error", err)
+	}
+}
+
+type SlotLimits struct {
+	UpMin   int
+	UpMax   int
+	DownMin int
+	DownMax int
+}
+
+func entryLimits(entry tplinkapi.BandwidthControlEntry) SlotLimits {
+	return SlotLimits{UpMin: entry.UpMin, UpMax: entry.UpMax, DownMin: entry.DownMin, DownMax: entry.DownMax}
+}
+
+// validateLimits checks that minimums do not exceed maximums and that no
+// maximum exceeds the total WAN bandwidth configured on the router.
+func validateLimits(limits SlotLimits, details tplinkapi.BandwidthControlDetail) error {
+	directions := []struct {
+		name          string
+		min, max, tot int
+	}{
+		{"upload", limits.UpMin, limits.UpMax, details.UpTotal},
+		{"download", limits.DownMin, limits.DownMax, details.DownTotal},
+	}
+	for _, d := range directions {
+		if d.min < 1 || d.max < 1 {
+			return &SoftError{Message: fmt.Sprintf("Minimum and maximum %s speed must be positive", d.name)}
+		}
+		if d.min > d.max {
+			return &SoftError{Message: fmt.Sprintf("Minimum %s speed %d is above the maximum %d", d.name, d.min, d.max)}
+		}
+		if d.tot > 0 && d.max > d.tot {
+			return &SoftError{Message: fmt.Sprintf("Maximum %s speed %d is above the router total %d", d.name, d.max, d.tot)}
+		}
+	}
+	return nil
+}
+
+func (api RouterApi) UpdateSlot(slotId int, limits SlotLimits, enabled bool) error {
+	return api.UpdateSlotContext(context.Background(), slotId, limits, enabled)
+}
+
+// UpdateSlotContext changes the speed limits of a slot and whether its
+// bandwidth entry is active. A disabled slot keeps its address range.
+func (api RouterApi) UpdateSlotContext(ctx context.Context, slotId int, limits SlotLimits, enabled bool) error {
+	service := api.withContext(ctx)
+	details, err := service.GetBandwidthControlDetails()
+	if err != nil {
+		return err
+	}
+	if err = validateLimits(limits, details); err != nil {
+		return err
+	}
+	slot, entry, err := api.getSlotEntry(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	if entryLimits(slotEntry(slot)) == limits && entryLimits(entry) == limits && slot.Enabled == enabled {
+		return nil
+	}
+
+	updated := entry
+	updated.UpMin, updated.UpMax = limits.UpMin, limits.UpMax
+	updated.DownMin, updated.DownMax = limits.DownMin, limits.DownMax
+	updated.Enabled = enabled
+	_, err = api.saveSlot(ctx, slot, entry, updated)
+	return err
+}
+
+func (api RouterApi) SetSlotEnabled(slotId int, enabled bool) error {
+	return api.SetSlotEnabledContext(context.Background(), slotId, enabled)
+}
+
+// SetSlotEnabledContext enables or disables a slot and keeps its limits.
+func (api RouterApi) SetSlotEnabledContext(ctx context.Context, slotId int, enabled bool) error {
+	slot, err := api.GetSlotContext(ctx, slotId)
+	if err != nil {
+		return err
+	}
+	return api.UpdateSlotContext(ctx, slotId, slot.Limits(), enabled)
+}
+
+func (api RouterApi) SetUserSlotsEnabled(userId int, enabled bool) (int, error) {
+	return api.SetUserSlotsEnabledContext(context.Background(), userId, enabled)
+}
+
+// SetUserSlotsEnabledContext enables or disables every slot of a user and
+// returns the number of slots changed.
+func (api RouterApi) SetUserSlotsEnabledContext(ctx context.Context, userId int, enabled bool) (int, error) {
+	if _, err := api.store.UserStore.ReadContext(ctx, userId); err != nil {
+		return 0, err
+	}
+	slots, err := api.getAllUserSlots(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, slot := range slots {
+		if slot.Enabled == enabled {
+			continue
+		}
+		if err = api.SetSlotEnabledContext(ctx, slot.Id, enabled); err != nil {
+			return changed, fmt.Errorf("slot %d: %w", slot.Id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
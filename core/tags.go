@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/omushpapa/routerman/storage"
+)
+
+// DeviceInfo is the descriptive part of a device, kept apart from its
+// owner and MAC address which have their own operations.
+type DeviceInfo struct {
+	Type   string
+	Vendor string
+	Notes  string
+	Tags   []string
+}
+
+// ParseTags reads a comma separated list of tags. Tags are lower case, and
+// empty and repeated ones are dropped.
+func ParseTags(value string) []string {
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (api RouterApi) SetUserInfo(userId int, contact, notes string) error {
+	return api.SetUserInfoContext(context.Background(), userId, contact, notes)
+}
+
+func (api RouterApi) SetUserInfoContext(ctx context.Context, userId int, contact, notes string) error {
+	user, err := api.store.UserStore.ReadContext(ctx, userId)
+	if err != nil {
+		return err
+	}
+	user.Contact = strings.TrimSpace(contact)
+	user.Notes = strings.TrimSpace(notes)
+	return api.store.UserStore.UpdateContext(ctx, user)
+}
+
+func (api RouterApi) SetDeviceInfo(deviceId int, info DeviceInfo) error {
+	return api.SetDeviceInfoContext(context.Background(), deviceId, info)
+}
+
+func (api RouterApi) SetDeviceInfoContext(ctx context.Context, deviceId int, info DeviceInfo) error {
+	device, err := api.store.DeviceStore.ReadContext(ctx, deviceId)
+	if err != nil {
+		return err
+	}
+	device.Type = strings.ToLower(strings.TrimSpace(info.Type))
+	device.Vendor = strings.TrimSpace(info.Vendor)
+	device.Notes = strings.TrimSpace(info.Notes)
+	device.Tags = ParseTags(strings.Join(info.Tags, ","))
+	return api.store.DeviceStore.UpdateContext(ctx, device)
+}
+
+func (api RouterApi) GetTags() (map[string]int, error) {
+	return api.GetTagsContext(context.Background())
+}
+
+// GetTagsContext returns the number of devices with each tag.
+func (api RouterApi) GetTagsContext(ctx context.Context) (map[string]int, error) {
+	return api.store.DeviceStore.ReadTagsContext(ctx)
+}
+
+func (api RouterApi) GetTaggedDevices(tag string) ([]storage.Device, error) {
+	return api.GetTaggedDevicesContext(context.Background(), tag)
+}
+
+func (api RouterApi) GetTaggedDevicesContext(ctx context.Context, tag string) ([]storage.Device, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	devices := make([]storage.Device, 0)
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := api.store.DeviceStore.ReadManyByTagContext(ctx, tag, pageSizeAll, pageNumber)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, page...)
+		if len(page) < pageSizeAll {
+			return devices, nil
+		}
+	}
+}
+
+func (api RouterApi) SetTagBlocked(tag string, blocked bool) (int, error) {
+	return api.SetTagBlockedContext(context.Background(), tag, blocked)
+}
+
+// SetTagBlockedContext blocks or unblocks every device with a tag. Devices
+// already in that state are skipped. It returns the number of devices
+// changed.
+func (api RouterApi) SetTagBlockedContext(ctx context.Context, tag string, blocked bool) (int, error) {
+	devices, err := api.GetTaggedDevicesContext(ctx, tag)
+	if err != nil {
+		return 0, err
+	}
+	if len(devices) == 0 {
+		return 0, &SoftError{Message: fmt.Sprintf("No devices tagged '%s'", tag)}
+	}
+	return api.setDevicesBlocked(ctx, devices, blocked)
+}
+
+// setDevicesBlocked blocks or unblocks devices that are not already in that
+// state and returns how many were changed.
+func (api RouterApi) setDevicesBlocked(ctx context.Context, devices []storage.Device, blocked bool) (int, error) {
+	blockedAddresses, err := api.getBlockedMacAddresses(ctx)
+	if err != nil {
+		return 0, err
+	}
+	isBlocked := make(map[string]bool, len(blockedAddresses))
+	for _, mac := range blockedAddresses {
+		isBlocked[strings.ToUpper(mac)] = true
+	}
+
+	changed := 0
+	for _, device := range devices {
+		if isBlocked[strings.ToUpper(device.Mac)] == blocked {
+			continue
+		}
+		if blocked {
+			err = api.BlockDeviceContext(ctx, device.Mac)
+		} else {
+			err = api.UnblockDeviceContext(ctx, device.Mac)
+		}
+		if err != nil {
+			return changed, fmt.Errorf("device %d: %w", device.Id, err)
+		}
+		isBlocked[strings.ToUpper(device.Mac)] = blocked
+		changed++
+	}
+	return changed, nil
+}
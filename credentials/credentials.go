@@ -0,0 +1,230 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var ErrNotFound = errors.New("credentials not found")
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type Provider interface {
+	Credentials() (Credentials, error)
+}
+
+type Store interface {
+	Provider
+	Save(creds Credentials) error
+}
+
+// Static serves fixed credentials, typically resolved from flags and
+// environment variables.
+type Static Credentials
+
+func (s Static) Credentials() (Credentials, error) {
+	if s.Password == "" {
+		return Credentials(s), ErrNotFound
+	}
+	return Credentials(s), nil
+}
+
+// Command runs a password manager command such as `pass show router` and
+// uses the first line of its output as the password.
+type Command struct {
+	Command  string
+	Username string
+}
+
+func (c Command) Credentials() (Credentials, error) {
+	creds := Credentials{Username: c.Username}
+	if c.Command == "" {
+		return creds, ErrNotFound
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", c.Command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return creds, fmt.Errorf("password command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if scanner.Scan() {
+		creds.Password = scanner.Text()
+	}
+	if creds.Password == "" {
+		return creds, fmt.Errorf("password command returned no password")
+	}
+	return creds, nil
+}
+
+func checkOwnerOnly(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("credentials file '%s' must only be accessible by its owner (chmod 600)", path)
+	}
+	return nil
+}
+
+func writeOwnerOnly(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
+}
+
+// File keeps credentials as plain JSON in a file readable only by its owner.
+type File struct {
+	Path string
+}
+
+func (f File) Credentials() (Credentials, error) {
+	var creds Credentials
+	if err := checkOwnerOnly(f.Path); err != nil {
+		return creds, err
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return creds, err
+	}
+	err = json.Unmarshal(data, &creds)
+	return creds, err
+}
+
+func (f File) Save(creds Credentials) error {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	return writeOwnerOnly(f.Path, data)
+}
+
+const (
+	kdfIterations = 600_000
+	keyLength     = 32
+	saltLength    = 16
+)
+
+type encryptedPayload struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedFile keeps credentials encrypted with AES-GCM under a key derived
+// from a passphrase with PBKDF2.
+type EncryptedFile struct {
+	Path       string
+	Passphrase func() (string, error)
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, kdfIterations, keyLength, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f EncryptedFile) Credentials() (Credentials, error) {
+	var (
+		creds   Credentials
+		payload encryptedPayload
+	)
+	if err := checkOwnerOnly(f.Path); err != nil {
+		return creds, err
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return creds, err
+	}
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return creds, err
+	}
+	passphrase, err := f.Passphrase()
+	if err != nil {
+		return creds, err
+	}
+	gcm, err := newGCM(passphrase, payload.Salt)
+	if err != nil {
+		return creds, err
+	}
+	plaintext, err := gcm.Open(nil, payload.Nonce, payload.Ciphertext, nil)
+	if err != nil {
+		return creds, fmt.Errorf("unable to decrypt credentials, wrong passphrase?")
+	}
+	err = json.Unmarshal(plaintext, &creds)
+	return creds, err
+}
+
+func (f EncryptedFile) Save(creds Credentials) error {
+	passphrase, err := f.Passphrase()
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("passphrase must not be empty")
+	}
+	payload := encryptedPayload{Salt: make([]byte, saltLength)}
+	if _, err = rand.Read(payload.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(passphrase, payload.Salt)
+	if err != nil {
+		return err
+	}
+	payload.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(payload.Nonce); err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	payload.Ciphertext = gcm.Seal(nil, payload.Nonce, plaintext, nil)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return writeOwnerOnly(f.Path, data)
+}
+
+// Chain returns the credentials of the first provider that has them.
+type Chain []Provider
+
+func (c Chain) Credentials() (Credentials, error) {
+	for _, provider := range c {
+		creds, err := provider.Credentials()
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return creds, err
+	}
+	return Credentials{}, ErrNotFound
+}
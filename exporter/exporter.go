@@ -0,0 +1,190 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omushpapa/routerman/core"
+)
+
+const namespace = "routerman"
+
+type label struct {
+	name  string
+	value string
+}
+
+// writer renders metrics in the Prometheus text exposition format.
+type writer struct {
+	out *bufio.Writer
+}
+
+func (w writer) family(name, kind, help string) {
+	fmt.Fprintf(w.out, "# HELP %s_%s %s\n", namespace, name, help)
+	fmt.Fprintf(w.out, "# TYPE %s_%s %s\n", namespace, name, kind)
+}
+
+func (w writer) sample(name string, value float64, labels ...label) {
+	w.out.WriteString(namespace + "_" + name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels))
+		for _, l := range labels {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", l.name, escape(l.value)))
+		}
+		w.out.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.out.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+func escape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+type Exporter struct {
+	env *core.Env
+}
+
+func New(env *core.Env) *Exporter {
+	return &Exporter{env: env}
+}
+
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	return mux
+}
+
+func (e *Exporter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	metrics, err := e.env.Router.GetMetricsContext(req.Context())
+	if err != nil {
+		e.env.Logger.Warn("collecting router metrics failed", "error", err)
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.Write(rw, metrics, err == nil)
+}
+
+// Write renders the router metrics followed by the router call counters.
+// When up is false only the call counters are meaningful.
+func (e *Exporter) Write(out io.Writer, metrics core.RouterMetrics, up bool) error {
+	w := writer{out: bufio.NewWriter(out)}
+
+	w.family("up", "gauge", "Whether the last collection from the router succeeded.")
+	if up {
+		w.sample("up", 1)
+		writeRouterMetrics(w, metrics)
+	} else {
+		w.sample("up", 0)
+	}
+	writeCallStats(w, e.env.Router.CallStats())
+	return w.out.Flush()
+}
+
+func writeRouterMetrics(w writer, metrics core.RouterMetrics) {
+	w.family("connected_clients", "gauge", "Number of clients connected to the router.")
+	w.sample("connected_clients", float64(len(metrics.Clients)))
+
+	// The router reports a single byte count per client without separating
+	// received and transmitted traffic.
+	w.family("device_bytes_total", "counter", "Bytes transferred by a connected device.")
+	userBytes := make(map[string]float64)
+	for _, client := range metrics.Clients {
+		w.sample(
+			"device_bytes_total", float64(client.Bytes),
+			label{"mac", client.Mac}, label{"ip", client.IP}, label{"alias", client.Alias}, label{"user", client.User},
+		)
+		if client.User != "" {
+			userBytes[client.User] += float64(client.Bytes)
+		}
+	}
+
+	w.family("user_bytes", "gauge", "Bytes transferred by the devices of a user that are connected now.")
+	users := make([]string, 0, len(userBytes))
+	for user := range userBytes {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		w.sample("user_bytes", userBytes[user], label{"user", user})
+	}
+
+	w.family("bandwidth_entry_enabled", "gauge", "Whether a bandwidth control entry is enabled.")
+	for _, slot := range metrics.Slots {
+		w.sample("bandwidth_entry_enabled", boolValue(slot.Enabled), slotLabels(slot)...)
+	}
+	w.family("bandwidth_limit_kbps", "gauge", "Bandwidth limit of a bandwidth control entry in kbps.")
+	for _, slot := range metrics.Slots {
+		limits := []struct {
+			direction, bound string
+			value            int
+		}{
+			{"up", "min", slot.UpMin},
+			{"up", "max", slot.UpMax},
+			{"down", "min", slot.DownMin},
+			{"down", "max", slot.DownMax},
+		}
+		for _, limit := range limits {
+			labels := append(slotLabels(slot), label{"direction", limit.direction}, label{"bound", limit.bound})
+			w.sample("bandwidth_limit_kbps", float64(limit.value), labels...)
+		}
+	}
+
+	w.family("blocked_devices", "gauge", "Number of devices denied internet access.")
+	w.sample("blocked_devices", float64(metrics.BlockedDevices))
+
+	w.family("dhcp_pool_addresses", "gauge", "Number of addresses in the DHCP pool.")
+	w.sample("dhcp_pool_addresses", float64(metrics.DhcpPoolSize))
+	w.family("dhcp_pool_used_addresses", "gauge", "Number of DHCP pool addresses held by connected clients.")
+	w.sample("dhcp_pool_used_addresses", float64(metrics.DhcpPoolUsed))
+	w.family("dhcp_pool_utilisation_ratio", "gauge", "Share of the DHCP pool held by connected clients.")
+	var ratio float64
+	if metrics.DhcpPoolSize > 0 {
+		ratio = float64(metrics.DhcpPoolUsed) / float64(metrics.DhcpPoolSize)
+	}
+	w.sample("dhcp_pool_utilisation_ratio", ratio)
+}
+
+func writeCallStats(w writer, calls map[string]core.CallStats) {
+	names := make([]string, 0, len(calls))
+	for name := range calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.family("router_calls_total", "counter", "Requests sent to the router.")
+	for _, name := range names {
+		w.sample("router_calls_total", float64(calls[name].Count), label{"call", name})
+	}
+	w.family("router_call_errors_total", "counter", "Requests to the router that failed.")
+	for _, name := range names {
+		w.sample("router_call_errors_total", float64(calls[name].Errors), label{"call", name})
+	}
+	w.family("router_call_duration_seconds", "summary", "Time spent waiting for the router.")
+	for _, name := range names {
+		w.sample("router_call_duration_seconds_sum", calls[name].Duration.Seconds(), label{"call", name})
+		w.sample("router_call_duration_seconds_count", float64(calls[name].Count), label{"call", name})
+	}
+}
+
+func slotLabels(slot core.SlotMetric) []label {
+	return []label{
+		{"entry", strconv.Itoa(slot.Id)},
+		{"user", slot.User},
+		{"start_ip", slot.StartIp},
+		{"end_ip", slot.EndIp},
+	}
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -1,15 +1,18 @@
 module github.com/omushpapa/routerman
 
-go 1.18
+go 1.21
 
 require (
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/midir99/sqload v1.0.2
 	github.com/omushpapa/tplinkapi v0.5.2-0.20221128192751-5832198f4842
 	github.com/spf13/cobra v1.6.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -3,6 +3,11 @@ BEGIN;
 DROP TABLE IF EXISTS users;
 DROP TABLE IF EXISTS devices;
 DROP TABLE IF EXISTS bw_slots;
+DROP TABLE IF EXISTS plans;
+DROP TABLE IF EXISTS slot_schedules;
+DROP TABLE IF EXISTS device_tags;
+DROP TABLE IF EXISTS user_groups;
+DROP TABLE IF EXISTS user_group_members;
 CREATE TABLE users(
     id INTEGER NOT NULL PRIMARY KEY,
     name TEXT NOT NULL
@@ -18,67 +23,348 @@ CREATE TABLE bw_slots(
     user_id INTEGER NOT NULL,
     remote_id INTEGER NOT NULL
 );
+PRAGMA user_version = 0;
 COMMIT;
 
+-- query: SchemaExists
+SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'
+
+-- query: GetSchemaVersion
+PRAGMA user_version
+
+-- query: MigrateRouterScope
+ALTER TABLE users ADD COLUMN router TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE bw_slots ADD COLUMN router TEXT NOT NULL DEFAULT 'default';
+CREATE TABLE devices_scoped(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    user_id INTEGER NOT NULL,
+    alias TEXT DEFAULT "" NOT NULL,
+    mac TEXT NOT NULL,
+    UNIQUE(router, mac)
+);
+INSERT INTO devices_scoped(id, user_id, alias, mac) SELECT id, user_id, alias, mac FROM devices;
+DROP TABLE devices;
+ALTER TABLE devices_scoped RENAME TO devices;
+
+-- query: MigrateSlotSettings
+ALTER TABLE bw_slots ADD COLUMN start_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE bw_slots ADD COLUMN end_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE bw_slots ADD COLUMN up_min INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE bw_slots ADD COLUMN up_max INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE bw_slots ADD COLUMN down_min INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE bw_slots ADD COLUMN down_max INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE bw_slots ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1;
+
+-- query: MigratePlans
+CREATE TABLE plans(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    name TEXT NOT NULL,
+    up_min INTEGER NOT NULL,
+    up_max INTEGER NOT NULL,
+    down_min INTEGER NOT NULL,
+    down_max INTEGER NOT NULL,
+    devices INTEGER NOT NULL,
+    UNIQUE(router, name)
+);
+ALTER TABLE bw_slots ADD COLUMN plan_id INTEGER NOT NULL DEFAULT 0;
+
+-- query: MigrateSlotSchedules
+CREATE TABLE slot_schedules(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    slot_id INTEGER NOT NULL,
+    days TEXT NOT NULL DEFAULT '',
+    start_time TEXT NOT NULL,
+    end_time TEXT NOT NULL,
+    up_min INTEGER NOT NULL,
+    up_max INTEGER NOT NULL,
+    down_min INTEGER NOT NULL,
+    down_max INTEGER NOT NULL
+);
+CREATE INDEX slot_schedules_slot ON slot_schedules(router, slot_id);
+
+-- query: MigrateDeviceSlots
+ALTER TABLE bw_slots ADD COLUMN device_id INTEGER NOT NULL DEFAULT 0;
+
+-- query: MigrateSearchIndexes
+CREATE INDEX devices_user ON devices(router, user_id);
+
+-- query: MigrateRecordDetails
+-- Rows from before this migration get the migration time as their timestamps.
+ALTER TABLE users ADD COLUMN contact TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMP;
+UPDATE users SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
+ALTER TABLE devices ADD COLUMN device_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN vendor TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE devices ADD COLUMN updated_at TIMESTAMP;
+UPDATE devices SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
+CREATE TABLE device_tags(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    device_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    UNIQUE(router, device_id, tag)
+);
+CREATE INDEX device_tags_tag ON device_tags(router, tag);
+
+-- query: MigrateGroups
+CREATE TABLE user_groups(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    name TEXT NOT NULL,
+    UNIQUE(router, name)
+);
+CREATE TABLE user_group_members(
+    id INTEGER NOT NULL PRIMARY KEY,
+    router TEXT NOT NULL DEFAULT 'default',
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    UNIQUE(router, group_id, user_id)
+);
+CREATE INDEX user_group_members_user ON user_group_members(router, user_id);
+
 -- query: CreateUser
-INSERT INTO users(name) VALUES($1) RETURNING id
+INSERT INTO users(
+    router, name, contact, notes, created_at, updated_at
+) VALUES($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) RETURNING id, created_at, updated_at
 
 -- query: GetUserById
-SELECT id, name FROM users WHERE id = $1
+SELECT id, name, contact, notes, created_at, updated_at FROM users WHERE router = $1 AND id = $2
+
+-- query: GetUsersByName
+SELECT id, name, contact, notes, created_at, updated_at FROM users WHERE router = $1 AND name = $2 ORDER BY id ASC LIMIT 2
 
 -- query: GetUsers
-SELECT id, name FROM users ORDER BY name ASC LIMIT $1 OFFSET $2
+SELECT id, name, contact, notes, created_at, updated_at FROM users WHERE router = $1 ORDER BY name ASC LIMIT $2 OFFSET $3
 
 -- query: UpdateUser
-UPDATE users SET name = $2 WHERE id = $1
+UPDATE users SET name = $1, contact = $2, notes = $3, updated_at = CURRENT_TIMESTAMP WHERE router = $4 AND id = $5
 
 -- query: DeleteUserById
-DELETE FROM users WHERE id = $1
+DELETE FROM users WHERE router = $1 AND id = $2
 
 -- query: CreateDevice
-INSERT INTO devices(user_id, alias, mac) VALUES($1, $2, $3) RETURNING id
+INSERT INTO devices(
+    router, user_id, alias, mac, device_type, vendor, notes, created_at, updated_at
+) VALUES($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) RETURNING id, created_at, updated_at
 
 -- query: GetDeviceById
-SELECT id, user_id, alias, mac FROM devices WHERE id = $1
+SELECT
+    d.id, d.user_id, d.alias, d.mac, d.device_type, d.vendor, d.notes, d.created_at, d.updated_at,
+    (SELECT COALESCE(GROUP_CONCAT(t.tag, ','), '') FROM device_tags t WHERE t.router = d.router AND t.device_id = d.id)
+FROM devices d
+WHERE d.router = $1 AND d.id = $2
 
 -- query: GetDevicesByMac
 SELECT
-    d.id, d.user_id, d.alias, d.mac, u.id, u.name
+    d.id, d.user_id, d.alias, d.mac, d.device_type, d.vendor, d.notes, d.created_at, d.updated_at,
+    (SELECT COALESCE(GROUP_CONCAT(t.tag, ','), '') FROM device_tags t WHERE t.router = d.router AND t.device_id = d.id),
+    u.id, u.name
 FROM devices d
 JOIN users u ON
     u.id = d.user_id
 WHERE
-    d.mac IN %s
+    d.router = $1
+    AND d.mac IN %s
 
 -- query: GetDevices
-SELECT id, user_id, alias, mac FROM devices ORDER BY id DESC LIMIT $1 OFFSET $2
+SELECT
+    d.id, d.user_id, d.alias, d.mac, d.device_type, d.vendor, d.notes, d.created_at, d.updated_at,
+    (SELECT COALESCE(GROUP_CONCAT(t.tag, ','), '') FROM device_tags t WHERE t.router = d.router AND t.device_id = d.id)
+FROM devices d
+WHERE d.router = $1
+ORDER BY d.id DESC
+LIMIT $2 OFFSET $3
 
 -- query: GetDevicesByUserId
-SELECT id, user_id, alias, mac FROM devices WHERE user_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3
+SELECT
+    d.id, d.user_id, d.alias, d.mac, d.device_type, d.vendor, d.notes, d.created_at, d.updated_at,
+    (SELECT COALESCE(GROUP_CONCAT(t.tag, ','), '') FROM device_tags t WHERE t.router = d.router AND t.device_id = d.id)
+FROM devices d
+WHERE d.router = $1 AND d.user_id = $2
+ORDER BY d.id DESC
+LIMIT $3 OFFSET $4
+
+-- query: GetDevicesByTag
+SELECT
+    d.id, d.user_id, d.alias, d.mac, d.device_type, d.vendor, d.notes, d.created_at, d.updated_at,
+    (SELECT COALESCE(GROUP_CONCAT(t.tag, ','), '') FROM device_tags t WHERE t.router = d.router AND t.device_id = d.id)
+FROM devices d
+JOIN device_tags t ON
+    t.router = d.router
+    AND t.device_id = d.id
+WHERE d.router = $1 AND t.tag = $2
+ORDER BY d.id DESC
+LIMIT $3 OFFSET $4
+
+-- query: SearchDevices
+SELECT
+    COALESCE(d.id, 0), u.id, COALESCE(d.alias, ''), COALESCE(d.mac, ''), u.id, u.name
+FROM users u
+LEFT JOIN devices d ON
+    d.router = u.router
+    AND d.user_id = u.id
+WHERE
+    u.router = $1
+    AND (
+        u.name LIKE $2 ESCAPE '\'
+        OR d.alias LIKE $3 ESCAPE '\'
+        OR REPLACE(REPLACE(d.mac, ':', ''), '-', '') LIKE $4 ESCAPE '\'
+    )
+ORDER BY u.name ASC, d.id DESC
+LIMIT $5 OFFSET $6
 
 -- query: UpdateDevice
-UPDATE devices SET user_id = $2, alias = $3, mac = $4 WHERE id = $1
+UPDATE devices SET
+    user_id = $1, alias = $2, mac = $3, device_type = $4, vendor = $5, notes = $6, updated_at = CURRENT_TIMESTAMP
+WHERE router = $7 AND id = $8
 
 -- query: DeleteDeviceById
-DELETE FROM devices WHERE id = $1
+DELETE FROM devices WHERE router = $1 AND id = $2
 
 -- query: DeleteDeviceByUserId
-DELETE FROM devices WHERE user_id = $1
+DELETE FROM devices WHERE router = $1 AND user_id = $2
+
+-- query: CreateDeviceTag
+INSERT INTO device_tags(router, device_id, tag) VALUES($1, $2, $3)
+
+-- query: DeleteDeviceTags
+DELETE FROM device_tags WHERE router = $1 AND device_id = $2
+
+-- query: DeleteDeviceTagsByUserId
+DELETE FROM device_tags WHERE router = $1 AND device_id IN (SELECT id FROM devices WHERE router = $2 AND user_id = $3)
+
+-- query: GetTags
+SELECT tag, COUNT(*) FROM device_tags WHERE router = $1 GROUP BY tag ORDER BY tag ASC
 
 -- query: CreateBandwidthSlot
-INSERT INTO bw_slots(user_id, remote_id) VALUES($1, $2) RETURNING id
+INSERT INTO bw_slots(
+    router, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id
+) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id
 
 -- query: GetBandwidthSlotById
-SELECT id, user_id, remote_id FROM bw_slots WHERE id = $1
+SELECT id, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id FROM bw_slots WHERE router = $1 AND id = $2
+
+-- query: GetBandwidthSlotByDeviceId
+SELECT id, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id FROM bw_slots WHERE router = $1 AND device_id = $2
 
 -- query: GetBandwidthSlots
-SELECT id, user_id, remote_id FROM bw_slots ORDER BY id DESC LIMIT $1 OFFSET $2
+SELECT id, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id FROM bw_slots WHERE router = $1 ORDER BY id DESC LIMIT $2 OFFSET $3
 
 -- query: GetBandwidthSlotsByUserId
-SELECT id, user_id, remote_id FROM bw_slots WHERE user_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3
+SELECT id, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id FROM bw_slots WHERE router = $1 AND user_id = $2 ORDER BY id DESC LIMIT $3 OFFSET $4
+
+-- query: UpdateBandwidthSlot
+UPDATE bw_slots SET
+    user_id = $1, remote_id = $2, start_ip = $3, end_ip = $4,
+    up_min = $5, up_max = $6, down_min = $7, down_max = $8, enabled = $9, plan_id = $10, device_id = $11
+WHERE router = $12 AND id = $13
 
 -- query: DeleteBandwidthSlotById
-DELETE FROM bw_slots WHERE id = $1
+DELETE FROM bw_slots WHERE router = $1 AND id = $2
 
 -- query: DeleteBandwidthSlotByUserId
-DELETE FROM bw_slots WHERE user_id = $1
\ No newline at end of file
+DELETE FROM bw_slots WHERE router = $1 AND user_id = $2
+
+-- query: GetBandwidthSlotsByPlanId
+SELECT id, user_id, remote_id, start_ip, end_ip, up_min, up_max, down_min, down_max, enabled, plan_id, device_id FROM bw_slots WHERE router = $1 AND plan_id = $2 ORDER BY id ASC
+
+-- query: ClearBandwidthSlotPlan
+UPDATE bw_slots SET plan_id = 0 WHERE router = $1 AND plan_id = $2
+
+-- query: CreatePlan
+INSERT INTO plans(router, name, up_min, up_max, down_min, down_max, devices) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id
+
+-- query: GetPlanById
+SELECT id, name, up_min, up_max, down_min, down_max, devices FROM plans WHERE router = $1 AND id = $2
+
+-- query: GetPlanByName
+SELECT id, name, up_min, up_max, down_min, down_max, devices FROM plans WHERE router = $1 AND name = $2
+
+-- query: GetPlans
+SELECT id, name, up_min, up_max, down_min, down_max, devices FROM plans WHERE router = $1 ORDER BY name ASC LIMIT $2 OFFSET $3
+
+-- query: UpdatePlan
+UPDATE plans SET name = $1, up_min = $2, up_max = $3, down_min = $4, down_max = $5, devices = $6 WHERE router = $7 AND id = $8
+
+-- query: DeletePlanById
+DELETE FROM plans WHERE router = $1 AND id = $2
+
+-- query: CreateSlotSchedule
+INSERT INTO slot_schedules(
+    router, slot_id, days, start_time, end_time, up_min, up_max, down_min, down_max
+) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+
+-- query: GetSlotScheduleById
+SELECT id, slot_id, days, start_time, end_time, up_min, up_max, down_min, down_max FROM slot_schedules WHERE router = $1 AND id = $2
+
+-- query: GetSlotSchedules
+SELECT id, slot_id, days, start_time, end_time, up_min, up_max, down_min, down_max FROM slot_schedules WHERE router = $1 ORDER BY id ASC LIMIT $2 OFFSET $3
+
+-- query: GetSlotSchedulesBySlotId
+SELECT id, slot_id, days, start_time, end_time, up_min, up_max, down_min, down_max FROM slot_schedules WHERE router = $1 AND slot_id = $2 ORDER BY id ASC
+
+-- query: DeleteSlotScheduleById
+DELETE FROM slot_schedules WHERE router = $1 AND id = $2
+
+-- query: DeleteSlotScheduleBySlotId
+DELETE FROM slot_schedules WHERE router = $1 AND slot_id = $2
+
+-- query: DeleteSlotScheduleByUserId
+DELETE FROM slot_schedules WHERE router = $1 AND slot_id IN (
+    SELECT id FROM bw_slots WHERE router = $2 AND user_id = $3
+)
+
+-- query: CreateGroup
+INSERT INTO user_groups(router, name) VALUES($1, $2) RETURNING id
+
+-- query: GetGroupById
+SELECT g.id, g.name, (SELECT COUNT(*) FROM user_group_members m WHERE m.router = g.router AND m.group_id = g.id)
+FROM user_groups g WHERE g.router = $1 AND g.id = $2
+
+-- query: GetGroupByName
+SELECT g.id, g.name, (SELECT COUNT(*) FROM user_group_members m WHERE m.router = g.router AND m.group_id = g.id)
+FROM user_groups g WHERE g.router = $1 AND g.name = $2
+
+-- query: GetGroups
+SELECT g.id, g.name, (SELECT COUNT(*) FROM user_group_members m WHERE m.router = g.router AND m.group_id = g.id)
+FROM user_groups g WHERE g.router = $1 ORDER BY g.name ASC LIMIT $2 OFFSET $3
+
+-- query: GetGroupsByUserId
+SELECT g.id, g.name, (SELECT COUNT(*) FROM user_group_members m WHERE m.router = g.router AND m.group_id = g.id)
+FROM user_groups g
+JOIN user_group_members gm ON
+    gm.router = g.router
+    AND gm.group_id = g.id
+WHERE g.router = $1 AND gm.user_id = $2
+ORDER BY g.name ASC
+
+-- query: DeleteGroupById
+DELETE FROM user_groups WHERE router = $1 AND id = $2
+
+-- query: CreateGroupMember
+INSERT OR IGNORE INTO user_group_members(router, group_id, user_id) VALUES($1, $2, $3)
+
+-- query: GetGroupMembers
+SELECT u.id, u.name, u.contact, u.notes, u.created_at, u.updated_at
+FROM users u
+JOIN user_group_members m ON
+    m.router = u.router
+    AND m.user_id = u.id
+WHERE m.router = $1 AND m.group_id = $2
+ORDER BY u.name ASC
+
+-- query: DeleteGroupMember
+DELETE FROM user_group_members WHERE router = $1 AND group_id = $2 AND user_id = $3
+
+-- query: DeleteGroupMembersByGroupId
+DELETE FROM user_group_members WHERE router = $1 AND group_id = $2
+
+-- query: DeleteGroupMembersByUserId
+DELETE FROM user_group_members WHERE router = $1 AND user_id = $2
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	_ "embed"
 
@@ -17,27 +20,85 @@ var dbScript string
 
 var Q = sqload.MustLoadFromString[struct {
 	InitDb                      string `query:"InitDb"`
+	SchemaExists                string `query:"SchemaExists"`
+	GetSchemaVersion            string `query:"GetSchemaVersion"`
+	MigrateRouterScope          string `query:"MigrateRouterScope"`
+	MigrateSlotSettings         string `query:"MigrateSlotSettings"`
+	MigratePlans                string `query:"MigratePlans"`
+	MigrateSlotSchedules        string `query:"MigrateSlotSchedules"`
+	MigrateDeviceSlots          string `query:"MigrateDeviceSlots"`
+	MigrateSearchIndexes        string `query:"MigrateSearchIndexes"`
+	MigrateRecordDetails        string `query:"MigrateRecordDetails"`
+	MigrateGroups               string `query:"MigrateGroups"`
 	CreateUser                  string `query:"CreateUser"`
 	GetUserById                 string `query:"GetUserById"`
 	GetUsers                    string `query:"GetUsers"`
+	GetUsersByName              string `query:"GetUsersByName"`
 	GetDevicesByMac             string `query:"GetDevicesByMac"`
 	GetDeviceById               string `query:"GetDeviceById"`
 	GetDevices                  string `query:"GetDevices"`
 	GetDevicesByUserId          string `query:"GetDevicesByUserId"`
+	GetDevicesByTag             string `query:"GetDevicesByTag"`
+	SearchDevices               string `query:"SearchDevices"`
 	UpdateUser                  string `query:"UpdateUser"`
 	DeleteUserById              string `query:"DeleteUserById"`
 	CreateDevice                string `query:"CreateDevice"`
 	UpdateDevice                string `query:"UpdateDevice"`
 	DeleteDeviceById            string `query:"DeleteDeviceById"`
 	DeleteDeviceByUserId        string `query:"DeleteDeviceByUserId"`
+	CreateDeviceTag             string `query:"CreateDeviceTag"`
+	DeleteDeviceTags            string `query:"DeleteDeviceTags"`
+	DeleteDeviceTagsByUserId    string `query:"DeleteDeviceTagsByUserId"`
+	GetTags                     string `query:"GetTags"`
 	CreateBandwidthSlot         string `query:"CreateBandwidthSlot"`
 	GetBandwidthSlotById        string `query:"GetBandwidthSlotById"`
+	GetBandwidthSlotByDeviceId  string `query:"GetBandwidthSlotByDeviceId"`
 	GetBandwidthSlots           string `query:"GetBandwidthSlots"`
 	GetBandwidthSlotsByUserId   string `query:"GetBandwidthSlotsByUserId"`
+	UpdateBandwidthSlot         string `query:"UpdateBandwidthSlot"`
 	DeleteBandwidthSlotById     string `query:"DeleteBandwidthSlotById"`
 	DeleteBandwidthSlotByUserId string `query:"DeleteBandwidthSlotByUserId"`
+	GetBandwidthSlotsByPlanId   string `query:"GetBandwidthSlotsByPlanId"`
+	ClearBandwidthSlotPlan      string `query:"ClearBandwidthSlotPlan"`
+	CreatePlan                  string `query:"CreatePlan"`
+	GetPlanById                 string `query:"GetPlanById"`
+	GetPlanByName               string `query:"GetPlanByName"`
+	GetPlans                    string `query:"GetPlans"`
+	UpdatePlan                  string `query:"UpdatePlan"`
+	DeletePlanById              string `query:"DeletePlanById"`
+	CreateSlotSchedule          string `query:"CreateSlotSchedule"`
+	GetSlotScheduleById         string `query:"GetSlotScheduleById"`
+	GetSlotSchedules            string `query:"GetSlotSchedules"`
+	GetSlotSchedulesBySlotId    string `query:"GetSlotSchedulesBySlotId"`
+	DeleteSlotScheduleById      string `query:"DeleteSlotScheduleById"`
+	DeleteSlotScheduleBySlotId  string `query:"DeleteSlotScheduleBySlotId"`
+	DeleteSlotScheduleByUserId  string `query:"DeleteSlotScheduleByUserId"`
+	CreateGroup                 string `query:"CreateGroup"`
+	GetGroupById                string `query:"GetGroupById"`
+	GetGroupByName              string `query:"GetGroupByName"`
+	GetGroups                   string `query:"GetGroups"`
+	GetGroupsByUserId           string `query:"GetGroupsByUserId"`
+	DeleteGroupById             string `query:"DeleteGroupById"`
+	CreateGroupMember           string `query:"CreateGroupMember"`
+	GetGroupMembers             string `query:"GetGroupMembers"`
+	DeleteGroupMember           string `query:"DeleteGroupMember"`
+	DeleteGroupMembersByGroupId string `query:"DeleteGroupMembersByGroupId"`
+	DeleteGroupMembersByUserId  string `query:"DeleteGroupMembersByUserId"`
 }](dbScript)
 
+var migrations = []string{
+	Q.MigrateRouterScope,
+	Q.MigrateSlotSettings,
+	Q.MigratePlans,
+	Q.MigrateSlotSchedules,
+	Q.MigrateDeviceSlots,
+	Q.MigrateSearchIndexes,
+	Q.MigrateRecordDetails,
+	Q.MigrateGroups,
+}
+
+const DefaultRouter = "default"
+
 type DbConfig struct {
 	Init bool
 	URI  string
@@ -47,13 +108,19 @@ type Store struct {
 	UserStore          UserStorage
 	DeviceStore        DeviceStorage
 	BandwidthSlotStore BandwidthSlotStorage
+	PlanStore          PlanStorage
+	ScheduleStore      SlotScheduleStorage
+	GroupStore         GroupStorage
 }
 
-func NewStore(db *sql.DB) *Store {
+func NewStore(db *sql.DB, router string) *Store {
 	return &Store{
-		UserStore:          UserStore{db: db},
-		DeviceStore:        DeviceStore{db: db},
-		BandwidthSlotStore: BandwidthSlotStore{db: db},
+		UserStore:          UserStore{db: db, router: router},
+		DeviceStore:        DeviceStore{db: db, router: router},
+		BandwidthSlotStore: BandwidthSlotStore{db: db, router: router},
+		PlanStore:          PlanStore{db: db, router: router},
+		ScheduleStore:      SlotScheduleStore{db: db, router: router},
+		GroupStore:         GroupStore{db: db, router: router},
 	}
 }
 
@@ -72,42 +139,140 @@ func ConnectDatabase(cfg DbConfig) (*sql.DB, error) {
 			return db, err
 		}
 	}
+	err = migrate(db)
 	return db, err
 }
 
+func migrate(db *sql.DB) error {
+	var tables int
+	err := db.QueryRow(Q.SchemaExists).Scan(&tables)
+	if err != nil || tables == 0 {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(Q.GetSchemaVersion).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type User struct {
-	Id   int
-	Name string
+	Id        int
+	Name      string
+	Contact   string
+	Notes     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func scanUser(row scanner, user *User) error {
+	return row.Scan(&user.Id, &user.Name, &user.Contact, &user.Notes, &user.CreatedAt, &user.UpdatedAt)
 }
 
 type UserStorage interface {
 	Create(user *User) error
+	CreateContext(ctx context.Context, user *User) error
 	Read(id int) (User, error)
+	ReadContext(ctx context.Context, id int) (User, error)
+	ReadByName(name string) (User, error)
+	ReadByNameContext(ctx context.Context, name string) (User, error)
 	ReadMany(pageSize, pageNumber int) ([]User, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]User, error)
 	Update(user User) error
+	UpdateContext(ctx context.Context, user User) error
 	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
 }
 
 type UserStore struct {
-	db *sql.DB
+	db     *sql.DB
+	router string
 }
 
 func (u UserStore) Create(user *User) error {
+	return u.CreateContext(context.Background(), user)
+}
+
+func (u UserStore) CreateContext(ctx context.Context, user *User) error {
 	db := u.db
-	return db.QueryRow(Q.CreateUser, user.Name).Scan(&user.Id)
+	return db.QueryRowContext(
+		ctx, Q.CreateUser, u.router, user.Name, user.Contact, user.Notes,
+	).Scan(&user.Id, &user.CreatedAt, &user.UpdatedAt)
 }
 
 func (u UserStore) Read(id int) (User, error) {
+	return u.ReadContext(context.Background(), id)
+}
+
+func (u UserStore) ReadContext(ctx context.Context, id int) (User, error) {
 	db := u.db
 	var user User
-	err := db.QueryRow(Q.GetUserById, id).Scan(&user.Id, &user.Name)
+	err := scanUser(db.QueryRowContext(ctx, Q.GetUserById, u.router, id), &user)
 	if err == sql.ErrNoRows {
 		return user, fmt.Errorf("user not found '%d'", id)
 	}
 	return user, err
 }
 
+func (u UserStore) ReadByName(name string) (User, error) {
+	return u.ReadByNameContext(context.Background(), name)
+}
+
+// ReadByNameContext fails unless exactly one user has the name.
+func (u UserStore) ReadByNameContext(ctx context.Context, name string) (User, error) {
+	db := u.db
+	var user User
+	rows, err := db.QueryContext(ctx, Q.GetUsersByName, u.router, name)
+	if err != nil {
+		return user, err
+	}
+	defer rows.Close()
+
+	found := 0
+	for rows.Next() {
+		if err := scanUser(rows, &user); err != nil {
+			return user, err
+		}
+		found++
+	}
+	if err = rows.Err(); err != nil {
+		return user, err
+	}
+	switch found {
+	case 0:
+		return user, fmt.Errorf("user not found '%s'", name)
+	case 1:
+		return user, nil
+	default:
+		return User{}, fmt.Errorf("several users named '%s'", name)
+	}
+}
+
 func (u UserStore) ReadMany(pageSize, pageNumber int) ([]User, error) {
+	return u.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (u UserStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]User, error) {
 	db := u.db
 	users := make([]User, 0)
 	limit := pageSize
@@ -116,7 +281,7 @@ func (u UserStore) ReadMany(pageSize, pageNumber int) ([]User, error) {
 		offset = (pageNumber - 1) * pageSize
 	}
 
-	rows, err := db.Query(Q.GetUsers, limit, offset)
+	rows, err := db.QueryContext(ctx, Q.GetUsers, u.router, limit, offset)
 	if err != nil {
 		return users, err
 	}
@@ -124,7 +289,7 @@ func (u UserStore) ReadMany(pageSize, pageNumber int) ([]User, error) {
 
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.Id, &user.Name)
+		err := scanUser(rows, &user)
 		if err != nil {
 			return users, err
 		}
@@ -135,23 +300,53 @@ func (u UserStore) ReadMany(pageSize, pageNumber int) ([]User, error) {
 }
 
 func (u UserStore) Update(user User) error {
+	return u.UpdateContext(context.Background(), user)
+}
+
+func (u UserStore) UpdateContext(ctx context.Context, user User) error {
 	db := u.db
-	_, err := db.Exec(Q.UpdateUser, user.Id, user.Name)
+	_, err := db.ExecContext(ctx, Q.UpdateUser, user.Name, user.Contact, user.Notes, u.router, user.Id)
 	return err
 }
 
 func (u UserStore) Delete(id int) error {
+	return u.DeleteContext(context.Background(), id)
+}
+
+func (u UserStore) DeleteContext(ctx context.Context, id int) error {
 	db := u.db
-	_, err := db.Exec(Q.DeleteUserById, id)
+	_, err := db.ExecContext(ctx, Q.DeleteUserById, u.router, id)
 	return err
 }
 
+// Device is a registered client. Type is a free-form kind such as phone,
+// laptop or iot. Tags are lower case and kept sorted.
 type Device struct {
-	Id     int
-	UserId int
-	Alias  string
-	Mac    string
-	user   User
+	Id        int
+	UserId    int
+	Alias     string
+	Mac       string
+	Type      string
+	Vendor    string
+	Notes     string
+	Tags      []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	user      User
+}
+
+func scanDevice(row scanner, device *Device, dest ...interface{}) error {
+	var tags string
+	err := row.Scan(append([]interface{}{
+		&device.Id, &device.UserId, &device.Alias, &device.Mac, &device.Type, &device.Vendor, &device.Notes,
+		&device.CreatedAt, &device.UpdatedAt, &tags,
+	}, dest...)...)
+	device.Tags = nil
+	if tags != "" {
+		device.Tags = strings.Split(tags, ",")
+		sort.Strings(device.Tags)
+	}
+	return err
 }
 
 func (device Device) GetUser(userStore UserStorage) (User, error) {
@@ -167,30 +362,77 @@ func (device Device) GetUser(userStore UserStorage) (User, error) {
 
 type DeviceStorage interface {
 	Create(device *Device) error
+	CreateContext(ctx context.Context, device *Device) error
 	Read(id int) (Device, error)
+	ReadContext(ctx context.Context, id int) (Device, error)
 	ReadMany(pageSize, pageNumber int) ([]Device, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Device, error)
 	ReadManyByUserId(userId int, pageSize, pageNumber int) ([]Device, error)
+	ReadManyByUserIdContext(ctx context.Context, userId int, pageSize, pageNumber int) ([]Device, error)
+	ReadManyByTag(tag string, pageSize, pageNumber int) ([]Device, error)
+	ReadManyByTagContext(ctx context.Context, tag string, pageSize, pageNumber int) ([]Device, error)
+	ReadTags() (map[string]int, error)
+	ReadTagsContext(ctx context.Context) (map[string]int, error)
 	ReadManyByMac(macAddress []string) ([]Device, error)
+	ReadManyByMacContext(ctx context.Context, macAddress []string) ([]Device, error)
+	Search(term string, pageSize, pageNumber int) ([]Device, error)
+	SearchContext(ctx context.Context, term string, pageSize, pageNumber int) ([]Device, error)
 	Update(device Device) error
+	UpdateContext(ctx context.Context, device Device) error
 	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
 	DeleteByUserId(userId int) error
+	DeleteByUserIdContext(ctx context.Context, userId int) error
 }
 
 type DeviceStore struct {
-	db *sql.DB
+	db     *sql.DB
+	router string
 }
 
 func (d DeviceStore) Create(device *Device) error {
-	db := d.db
-	return db.QueryRow(
-		Q.CreateDevice, device.UserId, device.Alias, device.Mac,
-	).Scan(&device.Id)
+	return d.CreateContext(context.Background(), device)
+}
+
+func (d DeviceStore) CreateContext(ctx context.Context, device *Device) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(
+		ctx, Q.CreateDevice, d.router, device.UserId, device.Alias, device.Mac, device.Type, device.Vendor, device.Notes,
+	).Scan(&device.Id, &device.CreatedAt, &device.UpdatedAt)
+	if err == nil {
+		err = d.writeTags(ctx, tx, *device)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeTags replaces the stored tags of a device with device.Tags.
+func (d DeviceStore) writeTags(ctx context.Context, tx *sql.Tx, device Device) error {
+	if _, err := tx.ExecContext(ctx, Q.DeleteDeviceTags, d.router, device.Id); err != nil {
+		return err
+	}
+	for _, tag := range device.Tags {
+		if _, err := tx.ExecContext(ctx, Q.CreateDeviceTag, d.router, device.Id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (d DeviceStore) Read(id int) (Device, error) {
+	return d.ReadContext(context.Background(), id)
+}
+
+func (d DeviceStore) ReadContext(ctx context.Context, id int) (Device, error) {
 	db := d.db
 	var device Device
-	err := db.QueryRow(Q.GetDeviceById, id).Scan(&device.Id, &device.UserId, &device.Alias, &device.Mac)
+	err := scanDevice(db.QueryRowContext(ctx, Q.GetDeviceById, d.router, id), &device)
 	if err == sql.ErrNoRows {
 		return device, fmt.Errorf("device not found '%d'", id)
 	}
@@ -198,19 +440,26 @@ func (d DeviceStore) Read(id int) (Device, error) {
 }
 
 func (d DeviceStore) ReadManyByMac(macAddresses []string) ([]Device, error) {
+	return d.ReadManyByMacContext(context.Background(), macAddresses)
+}
+
+func (d DeviceStore) ReadManyByMacContext(ctx context.Context, macAddresses []string) ([]Device, error) {
 	db := d.db
 	var (
 		devices []Device
 		query   strings.Builder
-		args    []interface{}
+		args    = []interface{}{d.router}
 		err     error
 	)
+	if len(macAddresses) == 0 {
+		return devices, nil
+	}
 	for i, mac := range macAddresses {
 		if i == 0 {
 			query.WriteString("(")
 		}
 		args = append(args, mac)
-		query.WriteString(fmt.Sprintf("$%d", i+1))
+		query.WriteString(fmt.Sprintf("$%d", i+2))
 
 		if i == len(macAddresses)-1 {
 			query.WriteString(")")
@@ -220,7 +469,7 @@ func (d DeviceStore) ReadManyByMac(macAddresses []string) ([]Device, error) {
 	}
 
 	q := fmt.Sprintf(Q.GetDevicesByMac, query.String())
-	rows, err := db.Query(q, args...)
+	rows, err := db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return devices, err
 	}
@@ -228,9 +477,7 @@ func (d DeviceStore) ReadManyByMac(macAddresses []string) ([]Device, error) {
 
 	for rows.Next() {
 		var device Device
-		err = rows.Scan(
-			&device.Id, &device.UserId, &device.Alias, &device.Mac, &device.user.Id, &device.user.Name,
-		)
+		err = scanDevice(rows, &device, &device.user.Id, &device.user.Name)
 		if err != nil {
 			return devices, err
 		}
@@ -243,7 +490,51 @@ func (d DeviceStore) ReadManyByMac(macAddresses []string) ([]Device, error) {
 	return devices, err
 }
 
+func (d DeviceStore) Search(term string, pageSize, pageNumber int) ([]Device, error) {
+	return d.SearchContext(context.Background(), term, pageSize, pageNumber)
+}
+
+// SearchContext returns devices whose alias, MAC address or user name
+// contains term, ignoring case. A MAC address matches with or without
+// separators. Users without devices matching by name are returned as a
+// device with Id 0.
+func (d DeviceStore) SearchContext(ctx context.Context, term string, pageSize, pageNumber int) ([]Device, error) {
+	db := d.db
+	devices := make([]Device, 0)
+	limit := pageSize
+	offset := 0
+	if pageNumber > 1 {
+		offset = (pageNumber - 1) * pageSize
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+	pattern := "%" + escaped + "%"
+	macPattern := "%" + strings.NewReplacer(":", "", "-", "").Replace(escaped) + "%"
+	rows, err := db.QueryContext(ctx, Q.SearchDevices, d.router, pattern, pattern, macPattern, limit, offset)
+	if err != nil {
+		return devices, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var device Device
+		err := rows.Scan(
+			&device.Id, &device.UserId, &device.Alias, &device.Mac, &device.user.Id, &device.user.Name,
+		)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
 func (d DeviceStore) ReadMany(pageSize, pageNumber int) ([]Device, error) {
+	return d.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (d DeviceStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Device, error) {
 	db := d.db
 	devices := make([]Device, 0)
 	limit := pageSize
@@ -252,7 +543,7 @@ func (d DeviceStore) ReadMany(pageSize, pageNumber int) ([]Device, error) {
 		offset = (pageNumber - 1) * pageSize
 	}
 
-	rows, err := db.Query(Q.GetDevices, limit, offset)
+	rows, err := db.QueryContext(ctx, Q.GetDevices, d.router, limit, offset)
 	if err != nil {
 		return devices, err
 	}
@@ -260,7 +551,7 @@ func (d DeviceStore) ReadMany(pageSize, pageNumber int) ([]Device, error) {
 
 	for rows.Next() {
 		var device Device
-		err := rows.Scan(&device.Id, &device.UserId, &device.Alias, &device.Mac)
+		err := scanDevice(rows, &device)
 		if err != nil {
 			return devices, err
 		}
@@ -271,6 +562,10 @@ func (d DeviceStore) ReadMany(pageSize, pageNumber int) ([]Device, error) {
 }
 
 func (d DeviceStore) ReadManyByUserId(userId int, pageSize, pageNumber int) ([]Device, error) {
+	return d.ReadManyByUserIdContext(context.Background(), userId, pageSize, pageNumber)
+}
+
+func (d DeviceStore) ReadManyByUserIdContext(ctx context.Context, userId int, pageSize, pageNumber int) ([]Device, error) {
 	db := d.db
 	devices := make([]Device, 0)
 	limit := pageSize
@@ -279,7 +574,7 @@ func (d DeviceStore) ReadManyByUserId(userId int, pageSize, pageNumber int) ([]D
 		offset = (pageNumber - 1) * pageSize
 	}
 
-	rows, err := db.Query(Q.GetDevicesByUserId, userId, limit, offset)
+	rows, err := db.QueryContext(ctx, Q.GetDevicesByUserId, d.router, userId, limit, offset)
 	if err != nil {
 		return devices, err
 	}
@@ -287,7 +582,7 @@ func (d DeviceStore) ReadManyByUserId(userId int, pageSize, pageNumber int) ([]D
 
 	for rows.Next() {
 		var device Device
-		err := rows.Scan(&device.Id, &device.UserId, &device.Alias, &device.Mac)
+		err := scanDevice(rows, &device)
 		if err != nil {
 			return devices, err
 		}
@@ -297,30 +592,141 @@ func (d DeviceStore) ReadManyByUserId(userId int, pageSize, pageNumber int) ([]D
 	return devices, rows.Err()
 }
 
-func (d DeviceStore) Update(device Device) error {
+func (d DeviceStore) ReadManyByTag(tag string, pageSize, pageNumber int) ([]Device, error) {
+	return d.ReadManyByTagContext(context.Background(), tag, pageSize, pageNumber)
+}
+
+func (d DeviceStore) ReadManyByTagContext(ctx context.Context, tag string, pageSize, pageNumber int) ([]Device, error) {
+	db := d.db
+	devices := make([]Device, 0)
+	limit := pageSize
+	offset := 0
+	if pageNumber > 1 {
+		offset = (pageNumber - 1) * pageSize
+	}
+
+	rows, err := db.QueryContext(ctx, Q.GetDevicesByTag, d.router, tag, limit, offset)
+	if err != nil {
+		return devices, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var device Device
+		err := scanDevice(rows, &device)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+func (d DeviceStore) ReadTags() (map[string]int, error) {
+	return d.ReadTagsContext(context.Background())
+}
+
+// ReadTagsContext returns the number of devices with each tag.
+func (d DeviceStore) ReadTagsContext(ctx context.Context) (map[string]int, error) {
 	db := d.db
-	_, err := db.Exec(
-		Q.UpdateDevice, device.Id, device.UserId, device.Alias, device.Mac,
+	tags := make(map[string]int)
+	rows, err := db.QueryContext(ctx, Q.GetTags, d.router)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			tag   string
+			count int
+		)
+		if err := rows.Scan(&tag, &count); err != nil {
+			return tags, err
+		}
+		tags[tag] = count
+	}
+
+	return tags, rows.Err()
+}
+
+func (d DeviceStore) Update(device Device) error {
+	return d.UpdateContext(context.Background(), device)
+}
+
+func (d DeviceStore) UpdateContext(ctx context.Context, device Device) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx, Q.UpdateDevice, device.UserId, device.Alias, device.Mac, device.Type, device.Vendor, device.Notes,
+		d.router, device.Id,
 	)
-	return err
+	if err == nil {
+		err = d.writeTags(ctx, tx, device)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (d DeviceStore) Delete(id int) error {
+	return d.DeleteContext(context.Background(), id)
+}
+
+func (d DeviceStore) DeleteContext(ctx context.Context, id int) error {
 	db := d.db
-	_, err := db.Exec(Q.DeleteDeviceById, id)
+	if _, err := db.ExecContext(ctx, Q.DeleteDeviceTags, d.router, id); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, Q.DeleteDeviceById, d.router, id)
 	return err
 }
 
 func (d DeviceStore) DeleteByUserId(userId int) error {
+	return d.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (d DeviceStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
 	db := d.db
-	_, err := db.Exec(Q.DeleteDeviceByUserId, userId)
+	if _, err := db.ExecContext(ctx, Q.DeleteDeviceTagsByUserId, d.router, d.router, userId); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, Q.DeleteDeviceByUserId, d.router, userId)
 	return err
 }
 
+// BandwidthSlot links a user to a bandwidth control entry on the router.
+// The range and limits are kept as well, so a disabled slot, which has no
+// router entry, can be restored.
 type BandwidthSlot struct {
 	Id       int
 	UserId   int
 	RemoteId int
+	StartIp  string
+	EndIp    string
+	UpMin    int
+	UpMax    int
+	DownMin  int
+	DownMax  int
+	Enabled  bool
+	PlanId   int
+	DeviceId int
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBandwidthSlot(row scanner, slot *BandwidthSlot) error {
+	return row.Scan(
+		&slot.Id, &slot.UserId, &slot.RemoteId, &slot.StartIp, &slot.EndIp,
+		&slot.UpMin, &slot.UpMax, &slot.DownMin, &slot.DownMax, &slot.Enabled, &slot.PlanId, &slot.DeviceId,
+	)
 }
 
 func (slot BandwidthSlot) GetUser(userStore UserStore) (User, error) {
@@ -333,33 +739,77 @@ func (slot BandwidthSlot) GetUser(userStore UserStore) (User, error) {
 
 type BandwidthSlotStorage interface {
 	Create(slot *BandwidthSlot) error
+	CreateContext(ctx context.Context, slot *BandwidthSlot) error
 	Read(id int) (BandwidthSlot, error)
+	ReadContext(ctx context.Context, id int) (BandwidthSlot, error)
+	ReadByDeviceId(deviceId int) (BandwidthSlot, error)
+	ReadByDeviceIdContext(ctx context.Context, deviceId int) (BandwidthSlot, error)
 	ReadMany(pageSize, pageNumber int) ([]BandwidthSlot, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]BandwidthSlot, error)
 	ReadManyByUserId(userId int, pageSize, pageNumber int) ([]BandwidthSlot, error)
+	ReadManyByUserIdContext(ctx context.Context, userId int, pageSize, pageNumber int) ([]BandwidthSlot, error)
+	Update(slot BandwidthSlot) error
+	UpdateContext(ctx context.Context, slot BandwidthSlot) error
 	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
 	DeleteByUserId(userId int) error
+	DeleteByUserIdContext(ctx context.Context, userId int) error
+	ReadManyByPlanId(planId int) ([]BandwidthSlot, error)
+	ReadManyByPlanIdContext(ctx context.Context, planId int) ([]BandwidthSlot, error)
+	ClearPlan(planId int) error
+	ClearPlanContext(ctx context.Context, planId int) error
 }
 
 type BandwidthSlotStore struct {
-	db *sql.DB
+	db     *sql.DB
+	router string
 }
 
 func (s BandwidthSlotStore) Create(slot *BandwidthSlot) error {
+	return s.CreateContext(context.Background(), slot)
+}
+
+func (s BandwidthSlotStore) CreateContext(ctx context.Context, slot *BandwidthSlot) error {
 	db := s.db
-	return db.QueryRow(Q.CreateBandwidthSlot, slot.UserId, slot.RemoteId).Scan(&slot.Id)
+	return db.QueryRowContext(
+		ctx, Q.CreateBandwidthSlot, s.router, slot.UserId, slot.RemoteId, slot.StartIp, slot.EndIp,
+		slot.UpMin, slot.UpMax, slot.DownMin, slot.DownMax, slot.Enabled, slot.PlanId, slot.DeviceId,
+	).Scan(&slot.Id)
 }
 
 func (s BandwidthSlotStore) Read(id int) (BandwidthSlot, error) {
+	return s.ReadContext(context.Background(), id)
+}
+
+func (s BandwidthSlotStore) ReadContext(ctx context.Context, id int) (BandwidthSlot, error) {
 	db := s.db
 	var slot BandwidthSlot
-	err := db.QueryRow(Q.GetBandwidthSlotById, id).Scan(&slot.Id, &slot.UserId, &slot.RemoteId)
+	err := scanBandwidthSlot(db.QueryRowContext(ctx, Q.GetBandwidthSlotById, s.router, id), &slot)
 	if err == sql.ErrNoRows {
 		return slot, fmt.Errorf("bandwidth slot not found '%d'", id)
 	}
 	return slot, err
 }
 
+func (s BandwidthSlotStore) ReadByDeviceId(deviceId int) (BandwidthSlot, error) {
+	return s.ReadByDeviceIdContext(context.Background(), deviceId)
+}
+
+func (s BandwidthSlotStore) ReadByDeviceIdContext(ctx context.Context, deviceId int) (BandwidthSlot, error) {
+	db := s.db
+	var slot BandwidthSlot
+	err := scanBandwidthSlot(db.QueryRowContext(ctx, Q.GetBandwidthSlotByDeviceId, s.router, deviceId), &slot)
+	if err == sql.ErrNoRows {
+		return slot, fmt.Errorf("no bandwidth slot for device '%d'", deviceId)
+	}
+	return slot, err
+}
+
 func (s BandwidthSlotStore) ReadMany(pageSize, pageNumber int) ([]BandwidthSlot, error) {
+	return s.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (s BandwidthSlotStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]BandwidthSlot, error) {
 	db := s.db
 	slots := make([]BandwidthSlot, 0)
 	limit := pageSize
@@ -368,7 +818,7 @@ func (s BandwidthSlotStore) ReadMany(pageSize, pageNumber int) ([]BandwidthSlot,
 		offset = (pageNumber - 1) * pageSize
 	}
 
-	rows, err := db.Query(Q.GetBandwidthSlots, limit, offset)
+	rows, err := db.QueryContext(ctx, Q.GetBandwidthSlots, s.router, limit, offset)
 	if err != nil {
 		return slots, err
 	}
@@ -376,7 +826,7 @@ func (s BandwidthSlotStore) ReadMany(pageSize, pageNumber int) ([]BandwidthSlot,
 
 	for rows.Next() {
 		var slot BandwidthSlot
-		err := rows.Scan(&slot.Id, &slot.UserId, &slot.RemoteId)
+		err := scanBandwidthSlot(rows, &slot)
 		if err != nil {
 			return slots, err
 		}
@@ -387,6 +837,10 @@ func (s BandwidthSlotStore) ReadMany(pageSize, pageNumber int) ([]BandwidthSlot,
 }
 
 func (s BandwidthSlotStore) ReadManyByUserId(userId int, pageSize, pageNumber int) ([]BandwidthSlot, error) {
+	return s.ReadManyByUserIdContext(context.Background(), userId, pageSize, pageNumber)
+}
+
+func (s BandwidthSlotStore) ReadManyByUserIdContext(ctx context.Context, userId int, pageSize, pageNumber int) ([]BandwidthSlot, error) {
 	db := s.db
 	slots := make([]BandwidthSlot, 0)
 	limit := pageSize
@@ -395,7 +849,7 @@ func (s BandwidthSlotStore) ReadManyByUserId(userId int, pageSize, pageNumber in
 		offset = (pageNumber - 1) * pageSize
 	}
 
-	rows, err := db.Query(Q.GetBandwidthSlotsByUserId, userId, limit, offset)
+	rows, err := db.QueryContext(ctx, Q.GetBandwidthSlotsByUserId, s.router, userId, limit, offset)
 	if err != nil {
 		return slots, err
 	}
@@ -403,7 +857,7 @@ func (s BandwidthSlotStore) ReadManyByUserId(userId int, pageSize, pageNumber in
 
 	for rows.Next() {
 		var slot BandwidthSlot
-		err := rows.Scan(&slot.Id, &slot.UserId, &slot.RemoteId)
+		err := scanBandwidthSlot(rows, &slot)
 		if err != nil {
 			return slots, err
 		}
@@ -413,13 +867,534 @@ func (s BandwidthSlotStore) ReadManyByUserId(userId int, pageSize, pageNumber in
 	return slots, rows.Err()
 }
 
+func (s BandwidthSlotStore) Update(slot BandwidthSlot) error {
+	return s.UpdateContext(context.Background(), slot)
+}
+
+func (s BandwidthSlotStore) UpdateContext(ctx context.Context, slot BandwidthSlot) error {
+	db := s.db
+	_, err := db.ExecContext(
+		ctx, Q.UpdateBandwidthSlot, slot.UserId, slot.RemoteId, slot.StartIp, slot.EndIp,
+		slot.UpMin, slot.UpMax, slot.DownMin, slot.DownMax, slot.Enabled, slot.PlanId, slot.DeviceId, s.router, slot.Id,
+	)
+	return err
+}
+
 func (s BandwidthSlotStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s BandwidthSlotStore) DeleteContext(ctx context.Context, id int) error {
 	db := s.db
-	_, err := db.Exec(Q.DeleteBandwidthSlotById, id)
+	_, err := db.ExecContext(ctx, Q.DeleteBandwidthSlotById, s.router, id)
 	return err
 }
+
 func (s BandwidthSlotStore) DeleteByUserId(userId int) error {
+	return s.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (s BandwidthSlotStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
 	db := s.db
-	_, err := db.Exec(Q.DeleteBandwidthSlotByUserId, userId)
+	_, err := db.ExecContext(ctx, Q.DeleteBandwidthSlotByUserId, s.router, userId)
+	return err
+}
+
+func (s BandwidthSlotStore) ReadManyByPlanId(planId int) ([]BandwidthSlot, error) {
+	return s.ReadManyByPlanIdContext(context.Background(), planId)
+}
+
+func (s BandwidthSlotStore) ReadManyByPlanIdContext(ctx context.Context, planId int) ([]BandwidthSlot, error) {
+	db := s.db
+	slots := make([]BandwidthSlot, 0)
+	rows, err := db.QueryContext(ctx, Q.GetBandwidthSlotsByPlanId, s.router, planId)
+	if err != nil {
+		return slots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slot BandwidthSlot
+		err := scanBandwidthSlot(rows, &slot)
+		if err != nil {
+			return slots, err
+		}
+		slots = append(slots, slot)
+	}
+
+	return slots, rows.Err()
+}
+
+// ClearPlan detaches all slots from a plan. The slots keep their limits.
+func (s BandwidthSlotStore) ClearPlan(planId int) error {
+	return s.ClearPlanContext(context.Background(), planId)
+}
+
+func (s BandwidthSlotStore) ClearPlanContext(ctx context.Context, planId int) error {
+	db := s.db
+	_, err := db.ExecContext(ctx, Q.ClearBandwidthSlotPlan, s.router, planId)
+	return err
+}
+
+// Plan is a named set of bandwidth limits and a default number of devices
+// that slots can be created from.
+type Plan struct {
+	Id      int
+	Name    string
+	UpMin   int
+	UpMax   int
+	DownMin int
+	DownMax int
+	Devices int
+}
+
+func scanPlan(row scanner, plan *Plan) error {
+	return row.Scan(&plan.Id, &plan.Name, &plan.UpMin, &plan.UpMax, &plan.DownMin, &plan.DownMax, &plan.Devices)
+}
+
+type PlanStorage interface {
+	Create(plan *Plan) error
+	CreateContext(ctx context.Context, plan *Plan) error
+	Read(id int) (Plan, error)
+	ReadContext(ctx context.Context, id int) (Plan, error)
+	ReadByName(name string) (Plan, error)
+	ReadByNameContext(ctx context.Context, name string) (Plan, error)
+	ReadMany(pageSize, pageNumber int) ([]Plan, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Plan, error)
+	Update(plan Plan) error
+	UpdateContext(ctx context.Context, plan Plan) error
+	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
+}
+
+type PlanStore struct {
+	db     *sql.DB
+	router string
+}
+
+func (p PlanStore) Create(plan *Plan) error {
+	return p.CreateContext(context.Background(), plan)
+}
+
+func (p PlanStore) CreateContext(ctx context.Context, plan *Plan) error {
+	db := p.db
+	return db.QueryRowContext(
+		ctx, Q.CreatePlan, p.router, plan.Name, plan.UpMin, plan.UpMax, plan.DownMin, plan.DownMax, plan.Devices,
+	).Scan(&plan.Id)
+}
+
+func (p PlanStore) Read(id int) (Plan, error) {
+	return p.ReadContext(context.Background(), id)
+}
+
+func (p PlanStore) ReadContext(ctx context.Context, id int) (Plan, error) {
+	db := p.db
+	var plan Plan
+	err := scanPlan(db.QueryRowContext(ctx, Q.GetPlanById, p.router, id), &plan)
+	if err == sql.ErrNoRows {
+		return plan, fmt.Errorf("plan not found '%d'", id)
+	}
+	return plan, err
+}
+
+func (p PlanStore) ReadByName(name string) (Plan, error) {
+	return p.ReadByNameContext(context.Background(), name)
+}
+
+func (p PlanStore) ReadByNameContext(ctx context.Context, name string) (Plan, error) {
+	db := p.db
+	var plan Plan
+	err := scanPlan(db.QueryRowContext(ctx, Q.GetPlanByName, p.router, name), &plan)
+	if err == sql.ErrNoRows {
+		return plan, fmt.Errorf("plan not found '%s'", name)
+	}
+	return plan, err
+}
+
+func (p PlanStore) ReadMany(pageSize, pageNumber int) ([]Plan, error) {
+	return p.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (p PlanStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Plan, error) {
+	db := p.db
+	plans := make([]Plan, 0)
+	limit := pageSize
+	offset := 0
+	if pageNumber > 1 {
+		offset = (pageNumber - 1) * pageSize
+	}
+
+	rows, err := db.QueryContext(ctx, Q.GetPlans, p.router, limit, offset)
+	if err != nil {
+		return plans, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var plan Plan
+		err := scanPlan(rows, &plan)
+		if err != nil {
+			return plans, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
+
+func (p PlanStore) Update(plan Plan) error {
+	return p.UpdateContext(context.Background(), plan)
+}
+
+func (p PlanStore) UpdateContext(ctx context.Context, plan Plan) error {
+	db := p.db
+	_, err := db.ExecContext(
+		ctx, Q.UpdatePlan, plan.Name, plan.UpMin, plan.UpMax, plan.DownMin, plan.DownMax, plan.Devices, p.router, plan.Id,
+	)
+	return err
+}
+
+func (p PlanStore) Delete(id int) error {
+	return p.DeleteContext(context.Background(), id)
+}
+
+func (p PlanStore) DeleteContext(ctx context.Context, id int) error {
+	db := p.db
+	_, err := db.ExecContext(ctx, Q.DeletePlanById, p.router, id)
+	return err
+}
+
+// SlotSchedule sets other limits for a slot between two times of day, on
+// the given days or every day when Days is empty. Days is a comma separated
+// list of "mon" to "sun" and times are "HH:MM".
+type SlotSchedule struct {
+	Id      int
+	SlotId  int
+	Days    string
+	Start   string
+	End     string
+	UpMin   int
+	UpMax   int
+	DownMin int
+	DownMax int
+}
+
+func scanSlotSchedule(row scanner, schedule *SlotSchedule) error {
+	return row.Scan(
+		&schedule.Id, &schedule.SlotId, &schedule.Days, &schedule.Start, &schedule.End,
+		&schedule.UpMin, &schedule.UpMax, &schedule.DownMin, &schedule.DownMax,
+	)
+}
+
+type SlotScheduleStorage interface {
+	Create(schedule *SlotSchedule) error
+	CreateContext(ctx context.Context, schedule *SlotSchedule) error
+	Read(id int) (SlotSchedule, error)
+	ReadContext(ctx context.Context, id int) (SlotSchedule, error)
+	ReadMany(pageSize, pageNumber int) ([]SlotSchedule, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]SlotSchedule, error)
+	ReadManyBySlotId(slotId int) ([]SlotSchedule, error)
+	ReadManyBySlotIdContext(ctx context.Context, slotId int) ([]SlotSchedule, error)
+	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
+	DeleteBySlotId(slotId int) error
+	DeleteBySlotIdContext(ctx context.Context, slotId int) error
+	DeleteByUserId(userId int) error
+	DeleteByUserIdContext(ctx context.Context, userId int) error
+}
+
+type SlotScheduleStore struct {
+	db     *sql.DB
+	router string
+}
+
+func (s SlotScheduleStore) Create(schedule *SlotSchedule) error {
+	return s.CreateContext(context.Background(), schedule)
+}
+
+func (s SlotScheduleStore) CreateContext(ctx context.Context, schedule *SlotSchedule) error {
+	db := s.db
+	return db.QueryRowContext(
+		ctx, Q.CreateSlotSchedule, s.router, schedule.SlotId, schedule.Days, schedule.Start, schedule.End,
+		schedule.UpMin, schedule.UpMax, schedule.DownMin, schedule.DownMax,
+	).Scan(&schedule.Id)
+}
+
+func (s SlotScheduleStore) Read(id int) (SlotSchedule, error) {
+	return s.ReadContext(context.Background(), id)
+}
+
+func (s SlotScheduleStore) ReadContext(ctx context.Context, id int) (SlotSchedule, error) {
+	db := s.db
+	var schedule SlotSchedule
+	err := scanSlotSchedule(db.QueryRowContext(ctx, Q.GetSlotScheduleById, s.router, id), &schedule)
+	if err == sql.ErrNoRows {
+		return schedule, fmt.Errorf("schedule not found '%d'", id)
+	}
+	return schedule, err
+}
+
+func (s SlotScheduleStore) ReadMany(pageSize, pageNumber int) ([]SlotSchedule, error) {
+	return s.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (s SlotScheduleStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]SlotSchedule, error) {
+	limit := pageSize
+	offset := 0
+	if pageNumber > 1 {
+		offset = (pageNumber - 1) * pageSize
+	}
+	return s.query(ctx, Q.GetSlotSchedules, s.router, limit, offset)
+}
+
+func (s SlotScheduleStore) ReadManyBySlotId(slotId int) ([]SlotSchedule, error) {
+	return s.ReadManyBySlotIdContext(context.Background(), slotId)
+}
+
+func (s SlotScheduleStore) ReadManyBySlotIdContext(ctx context.Context, slotId int) ([]SlotSchedule, error) {
+	return s.query(ctx, Q.GetSlotSchedulesBySlotId, s.router, slotId)
+}
+
+func (s SlotScheduleStore) query(ctx context.Context, query string, args ...interface{}) ([]SlotSchedule, error) {
+	db := s.db
+	schedules := make([]SlotSchedule, 0)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return schedules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schedule SlotSchedule
+		err := scanSlotSchedule(rows, &schedule)
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (s SlotScheduleStore) Delete(id int) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s SlotScheduleStore) DeleteContext(ctx context.Context, id int) error {
+	db := s.db
+	_, err := db.ExecContext(ctx, Q.DeleteSlotScheduleById, s.router, id)
+	return err
+}
+
+func (s SlotScheduleStore) DeleteBySlotId(slotId int) error {
+	return s.DeleteBySlotIdContext(context.Background(), slotId)
+}
+
+func (s SlotScheduleStore) DeleteBySlotIdContext(ctx context.Context, slotId int) error {
+	db := s.db
+	_, err := db.ExecContext(ctx, Q.DeleteSlotScheduleBySlotId, s.router, slotId)
+	return err
+}
+
+func (s SlotScheduleStore) DeleteByUserId(userId int) error {
+	return s.DeleteByUserIdContext(context.Background(), userId)
+}
+
+func (s SlotScheduleStore) DeleteByUserIdContext(ctx context.Context, userId int) error {
+	db := s.db
+	_, err := db.ExecContext(ctx, Q.DeleteSlotScheduleByUserId, s.router, s.router, userId)
+	return err
+}
+
+// Group is a named set of users, such as "family" or "staff". A user can be
+// in several groups.
+type Group struct {
+	Id      int
+	Name    string
+	Members int
+}
+
+func scanGroup(row scanner, group *Group) error {
+	return row.Scan(&group.Id, &group.Name, &group.Members)
+}
+
+type GroupStorage interface {
+	Create(group *Group) error
+	CreateContext(ctx context.Context, group *Group) error
+	Read(id int) (Group, error)
+	ReadContext(ctx context.Context, id int) (Group, error)
+	ReadByName(name string) (Group, error)
+	ReadByNameContext(ctx context.Context, name string) (Group, error)
+	ReadMany(pageSize, pageNumber int) ([]Group, error)
+	ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Group, error)
+	ReadManyByUserId(userId int) ([]Group, error)
+	ReadManyByUserIdContext(ctx context.Context, userId int) ([]Group, error)
+	Delete(id int) error
+	DeleteContext(ctx context.Context, id int) error
+	AddMember(groupId, userId int) error
+	AddMemberContext(ctx context.Context, groupId, userId int) error
+	ReadMembers(groupId int) ([]User, error)
+	ReadMembersContext(ctx context.Context, groupId int) ([]User, error)
+	RemoveMember(groupId, userId int) error
+	RemoveMemberContext(ctx context.Context, groupId, userId int) error
+	RemoveUser(userId int) error
+	RemoveUserContext(ctx context.Context, userId int) error
+}
+
+type GroupStore struct {
+	db     *sql.DB
+	router string
+}
+
+func (g GroupStore) Create(group *Group) error {
+	return g.CreateContext(context.Background(), group)
+}
+
+func (g GroupStore) CreateContext(ctx context.Context, group *Group) error {
+	db := g.db
+	return db.QueryRowContext(ctx, Q.CreateGroup, g.router, group.Name).Scan(&group.Id)
+}
+
+func (g GroupStore) Read(id int) (Group, error) {
+	return g.ReadContext(context.Background(), id)
+}
+
+func (g GroupStore) ReadContext(ctx context.Context, id int) (Group, error) {
+	db := g.db
+	var group Group
+	err := scanGroup(db.QueryRowContext(ctx, Q.GetGroupById, g.router, id), &group)
+	if err == sql.ErrNoRows {
+		return group, fmt.Errorf("group not found '%d'", id)
+	}
+	return group, err
+}
+
+func (g GroupStore) ReadByName(name string) (Group, error) {
+	return g.ReadByNameContext(context.Background(), name)
+}
+
+func (g GroupStore) ReadByNameContext(ctx context.Context, name string) (Group, error) {
+	db := g.db
+	var group Group
+	err := scanGroup(db.QueryRowContext(ctx, Q.GetGroupByName, g.router, name), &group)
+	if err == sql.ErrNoRows {
+		return group, fmt.Errorf("group not found '%s'", name)
+	}
+	return group, err
+}
+
+func (g GroupStore) ReadMany(pageSize, pageNumber int) ([]Group, error) {
+	return g.ReadManyContext(context.Background(), pageSize, pageNumber)
+}
+
+func (g GroupStore) ReadManyContext(ctx context.Context, pageSize, pageNumber int) ([]Group, error) {
+	db := g.db
+	limit := pageSize
+	offset := 0
+	if pageNumber > 1 {
+		offset = (pageNumber - 1) * pageSize
+	}
+
+	rows, err := db.QueryContext(ctx, Q.GetGroups, g.router, limit, offset)
+	if err != nil {
+		return make([]Group, 0), err
+	}
+	return g.scanGroups(rows)
+}
+
+func (g GroupStore) ReadManyByUserId(userId int) ([]Group, error) {
+	return g.ReadManyByUserIdContext(context.Background(), userId)
+}
+
+func (g GroupStore) ReadManyByUserIdContext(ctx context.Context, userId int) ([]Group, error) {
+	db := g.db
+	rows, err := db.QueryContext(ctx, Q.GetGroupsByUserId, g.router, userId)
+	if err != nil {
+		return make([]Group, 0), err
+	}
+	return g.scanGroups(rows)
+}
+
+func (g GroupStore) scanGroups(rows *sql.Rows) ([]Group, error) {
+	defer rows.Close()
+	groups := make([]Group, 0)
+	for rows.Next() {
+		var group Group
+		if err := scanGroup(rows, &group); err != nil {
+			return groups, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (g GroupStore) Delete(id int) error {
+	return g.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext removes a group and its memberships. The members are kept.
+func (g GroupStore) DeleteContext(ctx context.Context, id int) error {
+	db := g.db
+	if _, err := db.ExecContext(ctx, Q.DeleteGroupMembersByGroupId, g.router, id); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, Q.DeleteGroupById, g.router, id)
+	return err
+}
+
+func (g GroupStore) AddMember(groupId, userId int) error {
+	return g.AddMemberContext(context.Background(), groupId, userId)
+}
+
+// AddMemberContext adds a user to a group. Adding a member twice does
+// nothing.
+func (g GroupStore) AddMemberContext(ctx context.Context, groupId, userId int) error {
+	db := g.db
+	_, err := db.ExecContext(ctx, Q.CreateGroupMember, g.router, groupId, userId)
+	return err
+}
+
+func (g GroupStore) ReadMembers(groupId int) ([]User, error) {
+	return g.ReadMembersContext(context.Background(), groupId)
+}
+
+func (g GroupStore) ReadMembersContext(ctx context.Context, groupId int) ([]User, error) {
+	db := g.db
+	users := make([]User, 0)
+	rows, err := db.QueryContext(ctx, Q.GetGroupMembers, g.router, groupId)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (g GroupStore) RemoveMember(groupId, userId int) error {
+	return g.RemoveMemberContext(context.Background(), groupId, userId)
+}
+
+func (g GroupStore) RemoveMemberContext(ctx context.Context, groupId, userId int) error {
+	db := g.db
+	_, err := db.ExecContext(ctx, Q.DeleteGroupMember, g.router, groupId, userId)
+	return err
+}
+
+func (g GroupStore) RemoveUser(userId int) error {
+	return g.RemoveUserContext(context.Background(), userId)
+}
+
+// RemoveUserContext takes a user out of every group.
+func (g GroupStore) RemoveUserContext(ctx context.Context, userId int) error {
+	db := g.db
+	_, err := db.ExecContext(ctx, Q.DeleteGroupMembersByUserId, g.router, userId)
 	return err
 }